@@ -2,30 +2,147 @@ package echotemplates
 
 import (
 	"container/list"
+	"regexp"
 	"sync"
 	"time"
 )
 
-// cacheEntry represents a cached template
-type cacheEntry struct {
-	template    *parsedTemplate
-	modTime     time.Time
-	lastChecked time.Time
+// Cache stores parsed templates keyed by template path
+// Implement it to share parsed templates between instances, e.g. in Redis
+// Staleness is checked by the engine using CachedTemplate.ModTime and ETag
+type Cache interface {
+	// Get returns the cached template for key
+	Get(key string) (*CachedTemplate, bool)
+
+	// Put adds or replaces the cached template for key
+	Put(key string, template *CachedTemplate)
+
+	// Invalidate removes the cached template for key
+	Invalidate(key string)
+
+	// Clear removes all cached templates
+	Clear()
+}
+
+// CachedTemplate is the serializable form of a parsed template
+type CachedTemplate struct {
+	Metadata map[string]any
+	Content  string
+	Imports  []string
+	Issues   []*ParseError
+
+	// LineOffset is the number of front-matter lines before the content
+	LineOffset int
+
+	// ModTime and ETag identify the template version that was parsed
+	ModTime time.Time
+	ETag    string
+
+	// Dependencies are the imported templates of a flattened entry (see Config.FlattenImports)
+	Dependencies []TemplateVersion
+
+	// validators are the compiled validate.<name> patterns, entries restored
+	// by a serializing cache compile them again
+	validators map[string]*regexp.Regexp
+}
+
+// TemplateVersion identifies a version of a template
+type TemplateVersion struct {
+	Path    string
+	ModTime time.Time
+	ETag    string
+
+	// Absent marks a template that didn't exist, e.g. a skipped {{@primary|fallback}}
+	// alternative, the entry is stale once it appears
+	Absent bool
+}
+
+// isStale reports whether the cached template no longer matches the template version
+func (c *CachedTemplate) isStale(modTime time.Time, etag string) bool {
+	return TemplateVersion{ModTime: c.ModTime, ETag: c.ETag}.isStale(modTime, etag)
 }
 
-// templateCache implements an LRU cache for templates
+// isStale reports whether the template changed since this version
+func (v TemplateVersion) isStale(modTime time.Time, etag string) bool {
+	// Prefer content identity when both sides know it
+	if etag != "" && v.ETag != "" {
+		return etag != v.ETag
+	}
+	return modTime.After(v.ModTime)
+}
+
+// newCachedTemplate converts a parsed template to its cached form
+func newCachedTemplate(template *parsedTemplate, modTime time.Time, etag string) *CachedTemplate {
+	return &CachedTemplate{
+		Metadata:   template.metadata,
+		Content:    template.content,
+		Imports:    template.imports,
+		Issues:     template.issues,
+		LineOffset: template.lineOffset,
+		ModTime:    modTime,
+		ETag:       etag,
+		validators: template.validators,
+	}
+}
+
+// parsed converts the cached form back to a parsed template
+func (c *CachedTemplate) parsed() *parsedTemplate {
+	validators := c.validators
+	if validators == nil {
+		validators = compileValidators(c.Metadata)
+	}
+
+	return &parsedTemplate{
+		metadata:   c.Metadata,
+		content:    c.Content,
+		imports:    c.Imports,
+		issues:     c.Issues,
+		lineOffset: c.LineOffset,
+		validators: validators,
+	}
+}
+
+// getCached retrieves a template from cache if it exists and is still valid
+// When both the cached entry and the caller provide an ETag, it is used
+// instead of the modification time to decide if the entry is stale
+func getCached(cache Cache, key string, modTime time.Time, etag string) (*parsedTemplate, bool) {
+	cached, ok := cache.Get(key)
+	if !ok || cached == nil {
+		return nil, false
+	}
+
+	// Template has been modified, remove it from cache
+	if cached.isStale(modTime, etag) {
+		cache.Invalidate(key)
+		return nil, false
+	}
+	return cached.parsed(), true
+}
+
+// putCached stores a parsed template in the cache
+func putCached(cache Cache, key string, template *parsedTemplate, modTime time.Time, etag string) {
+	cache.Put(key, newCachedTemplate(template, modTime, etag))
+}
+
+// defaultCacheCheckInterval is how long a cached template is trusted without checking the source
+const defaultCacheCheckInterval = 5 * time.Second
+
+// templateCache is the built-in LRU Cache
 type templateCache struct {
 	mu        sync.RWMutex
 	entries   map[string]*list.Element
 	lru       *list.List
 	maxSize   int
 	checkFreq time.Duration
+	now       func() time.Time
 }
 
 // cacheItem is what we store in the LRU list
+// lastChecked is when the entry was last compared with the source
 type cacheItem struct {
-	key   string
-	entry *cacheEntry
+	key         string
+	template    *CachedTemplate
+	lastChecked time.Time
 }
 
 // newTemplateCache creates a new template cache
@@ -37,43 +154,73 @@ func newTemplateCache(maxSize int) *templateCache {
 		entries:   make(map[string]*list.Element),
 		lru:       list.New(),
 		maxSize:   maxSize,
-		checkFreq: 5 * time.Second, // Check file modification every 5 seconds
+		checkFreq: defaultCacheCheckInterval,
+		now:       time.Now,
 	}
 }
 
-// get retrieves a template from cache if it exists and is still valid
-func (c *templateCache) get(key string, fileModTime time.Time) (*parsedTemplate, bool) {
-	c.mu.RLock()
-	elem, exists := c.entries[key]
-	c.mu.RUnlock()
+// recent returns the cached template for key if it was checked against the source
+// within checkFreq, so the caller can skip the Stat call
+func (c *templateCache) recent(key string) (*CachedTemplate, bool) {
+	if c.checkFreq <= 0 {
+		return nil, false
+	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exists := c.entries[key]
 	if !exists {
 		return nil, false
 	}
+	item := elem.Value.(*cacheItem)
+	if c.now().Sub(item.lastChecked) > c.checkFreq {
+		return nil, false
+	}
 
+	c.lru.MoveToFront(elem)
+	return item.template, true
+}
+
+// checked records that the entry for key still matches the source
+func (c *templateCache) checked(key string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	item := elem.Value.(*cacheItem)
-	entry := item.entry
+	if elem, exists := c.entries[key]; exists {
+		elem.Value.(*cacheItem).lastChecked = c.now()
+	}
+}
 
-	// Check if file has been modified
-	if fileModTime.After(entry.modTime) {
-		// File has been modified, remove from cache
-		c.lru.Remove(elem)
-		delete(c.entries, key)
+// Get retrieves a template from the cache and marks it as recently used
+func (c *templateCache) Get(key string) (*CachedTemplate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exists := c.entries[key]
+	if !exists {
 		return nil, false
 	}
 
 	// Move to front (most recently used)
 	c.lru.MoveToFront(elem)
-	entry.lastChecked = time.Now()
+	return elem.Value.(*cacheItem).template, true
+}
+
+// refresh replaces the template of an existing entry without marking it as recently used
+func (c *templateCache) refresh(key string, template *CachedTemplate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	return entry.template, true
+	if elem, exists := c.entries[key]; exists {
+		item := elem.Value.(*cacheItem)
+		item.template = template
+		item.lastChecked = c.now()
+	}
 }
 
-// put adds or updates a template in the cache
-func (c *templateCache) put(key string, template *parsedTemplate, modTime time.Time) {
+// Put adds or updates a template in the cache
+func (c *templateCache) Put(key string, template *CachedTemplate) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -81,23 +228,17 @@ func (c *templateCache) put(key string, template *parsedTemplate, modTime time.T
 	if elem, exists := c.entries[key]; exists {
 		// Update existing entry
 		item := elem.Value.(*cacheItem)
-		item.entry.template = template
-		item.entry.modTime = modTime
-		item.entry.lastChecked = time.Now()
+		item.template = template
+		item.lastChecked = c.now()
 		c.lru.MoveToFront(elem)
 		return
 	}
 
 	// Add new entry
-	entry := &cacheEntry{
-		template:    template,
-		modTime:     modTime,
-		lastChecked: time.Now(),
-	}
-
 	item := &cacheItem{
-		key:   key,
-		entry: entry,
+		key:         key,
+		template:    template,
+		lastChecked: c.now(),
 	}
 
 	elem := c.lru.PushFront(item)
@@ -114,8 +255,8 @@ func (c *templateCache) put(key string, template *parsedTemplate, modTime time.T
 	}
 }
 
-// clear removes all entries from the cache
-func (c *templateCache) clear() {
+// Clear removes all entries from the cache
+func (c *templateCache) Clear() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -123,8 +264,8 @@ func (c *templateCache) clear() {
 	c.lru = list.New()
 }
 
-// remove removes a specific entry from the cache
-func (c *templateCache) remove(key string) {
+// Invalidate removes a specific entry from the cache
+func (c *templateCache) Invalidate(key string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -133,3 +274,25 @@ func (c *templateCache) remove(key string) {
 		delete(c.entries, key)
 	}
 }
+
+// unchecked marks the entry for key to be compared with the source on next use
+func (c *templateCache) unchecked(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, exists := c.entries[key]; exists {
+		elem.Value.(*cacheItem).lastChecked = time.Time{}
+	}
+}
+
+// items returns copies of all cached entries, least recently used first
+func (c *templateCache) items() []cacheItem {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	items := make([]cacheItem, 0, c.lru.Len())
+	for elem := c.lru.Back(); elem != nil; elem = elem.Prev() {
+		items = append(items, *elem.Value.(*cacheItem))
+	}
+	return items
+}
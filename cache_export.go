@@ -0,0 +1,82 @@
+package echotemplates
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+)
+
+// cacheExportVersion identifies the format written by ExportCache
+const cacheExportVersion = 1
+
+// cacheExport is the gob encoded form of the cache
+type cacheExport struct {
+	Version int
+	Entries []cacheExportEntry
+}
+
+// cacheExportEntry is a cached template with its key
+type cacheExportEntry struct {
+	Key      string
+	Template *CachedTemplate
+}
+
+func init() {
+	// Concrete types that front-matter values can hold
+	gob.Register([]string{})
+	gob.Register(map[string]any{})
+	gob.Register(map[string]string{})
+	gob.Register(map[string]map[string]any{})
+	gob.Register(map[string]map[string]string{})
+}
+
+// ExportCache writes all entries of the built-in cache, least recently used first
+func (e *templateEngine) ExportCache(w io.Writer) error {
+	cache := e.cacheState()
+	if cache == nil {
+		return fmt.Errorf("cache is disabled")
+	}
+	builtin, ok := cache.(*templateCache)
+	if !ok {
+		return fmt.Errorf("cache export requires the built-in cache")
+	}
+
+	data := cacheExport{Version: cacheExportVersion}
+	for _, item := range builtin.items() {
+		data.Entries = append(data.Entries, cacheExportEntry{Key: item.key, Template: item.template})
+	}
+
+	if err := gob.NewEncoder(w).Encode(data); err != nil {
+		return fmt.Errorf("failed to export cache: %w", err)
+	}
+	return nil
+}
+
+// ImportCache reads entries written by ExportCache and puts them into the cache
+func (e *templateEngine) ImportCache(r io.Reader) error {
+	cache := e.cacheState()
+	if cache == nil {
+		return fmt.Errorf("cache is disabled")
+	}
+
+	var data cacheExport
+	if err := gob.NewDecoder(r).Decode(&data); err != nil {
+		return fmt.Errorf("failed to import cache: %w", err)
+	}
+	if data.Version != cacheExportVersion {
+		return fmt.Errorf("unsupported cache export version %d", data.Version)
+	}
+
+	for _, entry := range data.Entries {
+		if entry.Template == nil {
+			continue
+		}
+		cache.Put(entry.Key, entry.Template)
+
+		// The source may have changed since the export, check it on first use
+		if builtin, ok := cache.(*templateCache); ok {
+			builtin.unchecked(entry.Key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package echotemplates
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestExportImportCache(t *testing.T) {
+	templates := map[string]string{
+		"main.md": `---
+model: gpt-4
+tags: [support, chat]
+default.tone: friendly
+validate.email: ^[^@]+@[^@]+$
+variants:
+  fast:
+    model: gpt-4o-mini
+---
+@system:
+You are {{tone}}.
+{{@shared}}
+
+@user:
+{{email}}`,
+		"shared.md": "Be brief.",
+		"toml.md":   "+++\n[limits]\ntokens = 100\n+++\n@user:\nHi",
+	}
+	vars := map[string]any{"email": "ann@example.com"}
+
+	exporter, err := New(Config{Source: NewMockSource(templates), FlattenImports: true})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	expected, err := exporter.Generate("main", vars)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := exporter.Generate("toml", nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := exporter.ExportCache(&buf); err != nil {
+		t.Fatalf("Failed to export cache: %v", err)
+	}
+
+	// A second engine with the imported cache doesn't parse templates
+	source := NewMockSource(templates)
+	parses := make(map[string]int)
+	var mu sync.Mutex
+	importer, err := New(Config{
+		Source:         source,
+		FlattenImports: true,
+		PreProcess: func(name, content string) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			parses[name]++
+			return content, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := importer.ImportCache(&buf); err != nil {
+		t.Fatalf("Failed to import cache: %v", err)
+	}
+
+	messages, metadata, err := importer.GenerateWithMetadata("main", vars, GenerateOptions{Variant: "fast"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(messages) != len(expected) || messages[0].Content != expected[0].Content || messages[1].Content != expected[1].Content {
+		t.Errorf("Expected %v, got %v", expected, messages)
+	}
+	if metadata["model"] != "gpt-4o-mini" {
+		t.Errorf("Expected variant model, got %v", metadata["model"])
+	}
+	if _, err := importer.Generate("toml", nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(parses) != 0 {
+		t.Errorf("Expected imported entries to be used, got parses %v", parses)
+	}
+
+	// Validators survive the round trip
+	if _, err := importer.Generate("main", map[string]any{"email": "ann"}); err == nil {
+		t.Error("Expected validation error for imported template")
+	}
+
+	// Imported entries are used until the source changes
+	source.templates["shared.md"] = "Be detailed."
+	messages, err = importer.Generate("main", vars)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(messages[0].Content, "Be detailed.") {
+		t.Errorf("Expected changed import to be reloaded, got %q", messages[0].Content)
+	}
+	if parses["shared.md"] != 1 || parses["main.md"] != 0 {
+		t.Errorf("Expected only the changed template to be parsed, got %v", parses)
+	}
+}
+
+func TestExportCacheErrors(t *testing.T) {
+	source := NewMockSource(map[string]string{"a.md": "@user:\nHi"})
+
+	t.Run("dev mode", func(t *testing.T) {
+		engine, err := New(Config{Source: source, DevMode: true})
+		if err != nil {
+			t.Fatalf("Failed to create engine: %v", err)
+		}
+		if err := engine.ExportCache(&bytes.Buffer{}); err == nil {
+			t.Error("Expected error exporting a disabled cache")
+		}
+	})
+
+	t.Run("custom cache", func(t *testing.T) {
+		engine, err := New(Config{Source: source, Cache: &mapCache{entries: make(map[string]CachedTemplate)}})
+		if err != nil {
+			t.Fatalf("Failed to create engine: %v", err)
+		}
+		if err := engine.ExportCache(&bytes.Buffer{}); err == nil {
+			t.Error("Expected error exporting a custom cache")
+		}
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		engine, err := New(Config{Source: source})
+		if err != nil {
+			t.Fatalf("Failed to create engine: %v", err)
+		}
+		if err := engine.ImportCache(strings.NewReader("not a cache")); err == nil {
+			t.Error("Expected error importing invalid data")
+		}
+	})
+}
@@ -1,6 +1,11 @@
 package echotemplates
 
 import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -29,9 +34,9 @@ func TestTemplateCache(t *testing.T) {
 	now := time.Now()
 
 	// Test basic put and get
-	cache.put("key1", template1, now)
+	putCached(cache, "key1", template1, now, "")
 
-	got, ok := cache.get("key1", now)
+	got, ok := getCached(cache, "key1", now, "")
 	if !ok {
 		t.Error("Expected to find key1 in cache")
 	}
@@ -40,43 +45,43 @@ func TestTemplateCache(t *testing.T) {
 	}
 
 	// Test cache miss
-	_, ok = cache.get("nonexistent", now)
+	_, ok = getCached(cache, "nonexistent", now, "")
 	if ok {
 		t.Error("Expected cache miss for nonexistent key")
 	}
 
 	// Test file modification invalidation
 	laterTime := now.Add(1 * time.Second)
-	_, ok = cache.get("key1", laterTime)
+	_, ok = getCached(cache, "key1", laterTime, "")
 	if ok {
 		t.Error("Expected cache miss due to file modification")
 	}
 
 	// Test LRU eviction
-	cache.put("key1", template1, now)
-	cache.put("key2", template2, now)
-	cache.put("key3", template3, now)
+	putCached(cache, "key1", template1, now, "")
+	putCached(cache, "key2", template2, now, "")
+	putCached(cache, "key3", template3, now, "")
 
 	// Access key1 to make it most recently used
-	cache.get("key1", now)
+	getCached(cache, "key1", now, "")
 
 	// Add key4, which should evict key2 (least recently used)
-	cache.put("key4", template4, now)
+	putCached(cache, "key4", template4, now, "")
 
-	_, ok = cache.get("key2", now)
+	_, ok = getCached(cache, "key2", now, "")
 	if ok {
 		t.Error("Expected key2 to be evicted")
 	}
 
 	// key1 should still be there
-	_, ok = cache.get("key1", now)
+	_, ok = getCached(cache, "key1", now, "")
 	if !ok {
 		t.Error("Expected key1 to still be in cache")
 	}
 
 	// Test clear
-	cache.clear()
-	_, ok = cache.get("key1", now)
+	cache.Clear()
+	_, ok = getCached(cache, "key1", now, "")
 	if ok {
 		t.Error("Expected cache to be empty after clear")
 	}
@@ -97,12 +102,12 @@ func TestCacheUpdate(t *testing.T) {
 	now := time.Now()
 
 	// Put original
-	cache.put("key1", template1, now)
+	putCached(cache, "key1", template1, now, "")
 
 	// Update with new content
-	cache.put("key1", template2, now)
+	putCached(cache, "key1", template2, now, "")
 
-	got, ok := cache.get("key1", now)
+	got, ok := getCached(cache, "key1", now, "")
 	if !ok {
 		t.Error("Expected to find key1 in cache")
 	}
@@ -125,7 +130,7 @@ func TestCacheConcurrency(t *testing.T) {
 				content: string(rune('A' + id)),
 			}
 			for j := 0; j < 100; j++ {
-				cache.put(string(rune('A'+id)), template, now)
+				putCached(cache, string(rune('A'+id)), template, now, "")
 			}
 			done <- true
 		}(i)
@@ -135,7 +140,7 @@ func TestCacheConcurrency(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		go func(id int) {
 			for j := 0; j < 100; j++ {
-				cache.get(string(rune('A'+id)), now)
+				getCached(cache, string(rune('A'+id)), now, "")
 			}
 			done <- true
 		}(i)
@@ -148,3 +153,374 @@ func TestCacheConcurrency(t *testing.T) {
 
 	// Should not panic or deadlock
 }
+
+func TestCacheETag(t *testing.T) {
+	cache := newTemplateCache(10)
+
+	template := &parsedTemplate{
+		content: "Tagged",
+	}
+
+	now := time.Now()
+	later := now.Add(1 * time.Second)
+
+	putCached(cache, "key1", template, now, "v1")
+
+	// Same ETag is a hit even if modtime moved forward
+	if _, ok := getCached(cache, "key1", later, "v1"); !ok {
+		t.Error("Expected cache hit for matching ETag")
+	}
+
+	// Different ETag is a miss even if modtime is unchanged
+	if _, ok := getCached(cache, "key1", now, "v2"); ok {
+		t.Error("Expected cache miss for changed ETag")
+	}
+
+	// Entry was evicted by the miss
+	if _, ok := getCached(cache, "key1", now, "v1"); ok {
+		t.Error("Expected stale entry to be removed")
+	}
+
+	// Without ETag fall back to modtime comparison
+	putCached(cache, "key2", template, now, "")
+	if _, ok := getCached(cache, "key2", now, "v1"); !ok {
+		t.Error("Expected cache hit using modtime")
+	}
+	if _, ok := getCached(cache, "key2", later, "v1"); ok {
+		t.Error("Expected cache miss using modtime")
+	}
+}
+
+func TestCacheETagSources(t *testing.T) {
+	mock := NewMockSource(map[string]string{
+		"a.md": "Template A",
+		"b.md": "Template B",
+	})
+
+	infoA, _ := mock.Stat("a.md")
+	infoA2, _ := mock.Stat("a.md")
+	infoB, _ := mock.Stat("b.md")
+
+	if infoA.ETag == "" {
+		t.Fatal("Expected mock source to populate ETag")
+	}
+	if infoA.ETag != infoA2.ETag {
+		t.Error("Expected stable ETag for the same content")
+	}
+	if infoA.ETag == infoB.ETag {
+		t.Error("Expected different ETag for different content")
+	}
+
+	engine, err := New(Config{Source: mock})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if _, err := engine.Generate("a", nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Mock modtime is always now, so only the ETag allows a cache hit
+	cache := engine.(*templateEngine).cache
+	if _, ok := getCached(cache, "a.md", time.Now(), infoA.ETag); !ok {
+		t.Error("Expected template to be cached by ETag")
+	}
+}
+
+// mapCache is a minimal Cache backed by a map, standing in for a remote store
+type mapCache struct {
+	mu      sync.Mutex
+	entries map[string]CachedTemplate
+	gets    int
+	puts    int
+}
+
+func (c *mapCache) Get(key string) (*CachedTemplate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gets++
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	return &entry, true
+}
+
+func (c *mapCache) Put(key string, template *CachedTemplate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.puts++
+	c.entries[key] = *template
+}
+
+func (c *mapCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+func (c *mapCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]CachedTemplate)
+}
+
+// gobCache stores entries gob encoded, dropping everything that isn't serialized
+type gobCache struct {
+	mapCache
+}
+
+func (c *gobCache) Put(key string, template *CachedTemplate) {
+	var buf bytes.Buffer
+	var decoded CachedTemplate
+	if err := gob.NewEncoder(&buf).Encode(template); err != nil {
+		return
+	}
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		return
+	}
+	c.mapCache.Put(key, &decoded)
+}
+
+func TestCustomCache(t *testing.T) {
+	cache := &mapCache{entries: make(map[string]CachedTemplate)}
+	mock := NewMockSource(map[string]string{
+		"main.md": "---\nmodel: gpt-4\n---\nHello {{name}}",
+	})
+
+	engine, err := New(Config{Source: mock, Cache: cache})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		messages, err := engine.Generate("main", map[string]any{"name": "World"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if messages[0].Content != "Hello World" {
+			t.Errorf("Expected 'Hello World', got %q", messages[0].Content)
+		}
+	}
+
+	// Parsed once, then served from the custom cache
+	if cache.puts != 1 || cache.gets != 2 {
+		t.Errorf("Expected 1 put and 2 gets, got %d puts and %d gets", cache.puts, cache.gets)
+	}
+	entry, ok := cache.entries["main.md"]
+	if !ok || entry.Content != "Hello {{name}}" || entry.Metadata["model"] != "gpt-4" || entry.ETag == "" {
+		t.Errorf("Unexpected cached entry: %+v", entry)
+	}
+
+	// A changed template invalidates the stale entry
+	mock.templates["main.md"] = "Hi {{name}}"
+	messages, err := engine.Generate("main", map[string]any{"name": "World"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if messages[0].Content != "Hi World" {
+		t.Errorf("Expected 'Hi World', got %q", messages[0].Content)
+	}
+
+	engine.ClearCache()
+	if len(cache.entries) != 0 {
+		t.Error("Expected ClearCache to clear the custom cache")
+	}
+}
+
+func TestCacheDisabledByFrontMatter(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"cached.md":   "@user:\nHello",
+		"dynamic.md":  "---\ncache: false\n---\n@user:\nNow",
+		"toml.md":     "+++\ncache = false\n+++\n@user:\nNow",
+		"composed.md": "@user:\n{{@dynamic}}",
+	})
+
+	parses := make(map[string]int)
+	var mu sync.Mutex
+	engine, err := New(Config{
+		Source:         source,
+		FlattenImports: true,
+		PreProcess: func(name, content string) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			parses[name]++
+			return content, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		for _, name := range []string{"cached", "dynamic", "toml", "composed"} {
+			if _, err := engine.Generate(name, nil, GenerateOptions{StrictMode: true}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+	}
+
+	expected := map[string]int{"cached.md": 1, "dynamic.md": 6, "toml.md": 3, "composed.md": 1}
+	for name, count := range expected {
+		if parses[name] != count {
+			t.Errorf("Expected %s to be parsed %d times, got %d", name, count, parses[name])
+		}
+	}
+
+	// The key is consumed by the engine
+	_, metadata, err := engine.GenerateWithMetadata("dynamic", nil, GenerateOptions{StripInternalMetadata: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := metadata["cache"]; ok {
+		t.Errorf("Expected cache key to be stripped, got %v", metadata)
+	}
+}
+
+// statCountingSource counts Stat calls per path of the wrapped mock source
+type statCountingSource struct {
+	*MockSource
+	mu    sync.Mutex
+	stats map[string]int
+}
+
+func (s *statCountingSource) Stat(path string) (TemplateInfo, error) {
+	s.mu.Lock()
+	s.stats[path]++
+	s.mu.Unlock()
+	return s.MockSource.Stat(path)
+}
+
+func (s *statCountingSource) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats[path]
+}
+
+func TestCacheCheckInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		// expected Stat calls after each step: first load, cached load, after 3s, after 6s
+		stats []int
+	}{
+		{"default interval", 0, []int{1, 1, 1, 2}},
+		{"custom interval", 2 * time.Second, []int{1, 1, 2, 3}},
+		{"check every time", -1, []int{1, 2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &statCountingSource{
+				MockSource: NewMockSource(map[string]string{"greeting.md": "@user:\nHello"}),
+				stats:      make(map[string]int),
+			}
+			engine, err := New(Config{Source: source, CacheCheckInterval: tt.interval})
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+
+			now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			cache := engine.(*templateEngine).cacheState().(*templateCache)
+			cache.now = func() time.Time { return now }
+
+			steps := []time.Duration{0, 0, 3 * time.Second, 3 * time.Second}
+			for i, step := range steps {
+				now = now.Add(step)
+				if _, err := engine.Generate("greeting", nil); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if got := source.count("greeting.md"); got != tt.stats[i] {
+					t.Errorf("Step %d: expected %d Stat calls, got %d", i, tt.stats[i], got)
+				}
+			}
+		})
+	}
+
+	t.Run("changes are picked up after the interval", func(t *testing.T) {
+		source := NewMockSource(map[string]string{"greeting.md": "@user:\nHello"})
+		engine, err := New(Config{Source: source})
+		if err != nil {
+			t.Fatalf("Failed to create engine: %v", err)
+		}
+
+		now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		cache := engine.(*templateEngine).cacheState().(*templateCache)
+		cache.now = func() time.Time { return now }
+
+		generate := func(expected string) {
+			t.Helper()
+			messages, err := engine.Generate("greeting", nil)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if messages[0].Content != expected {
+				t.Errorf("Expected %q, got %q", expected, messages[0].Content)
+			}
+		}
+
+		generate("Hello")
+		source.templates["greeting.md"] = "@user:\nHi"
+		generate("Hello")
+
+		now = now.Add(6 * time.Second)
+		generate("Hi")
+	})
+}
+
+// swappableSource serves a replaceable mock source and can fail Stat calls
+type swappableSource struct {
+	*MockSource
+	statErr error
+}
+
+func (s *swappableSource) Stat(path string) (TemplateInfo, error) {
+	if s.statErr != nil {
+		return TemplateInfo{}, s.statErr
+	}
+	return s.MockSource.Stat(path)
+}
+
+func TestRefreshEntries(t *testing.T) {
+	templates := map[string]string{
+		"a.md": "@user:\nA",
+		"b.md": "@user:\nB",
+		"c.md": "@user:\nC",
+	}
+	source := &swappableSource{MockSource: NewMockSource(templates)}
+	engine, err := New(Config{Source: source})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	impl := engine.(*templateEngine)
+
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := engine.Generate(name, nil); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	cache := impl.cacheState().(*templateCache)
+	order := func() []string {
+		var keys []string
+		for _, item := range cache.items() {
+			keys = append(keys, item.key+"="+item.template.Content)
+		}
+		return keys
+	}
+
+	// A failing source keeps every entry
+	source.statErr = errors.New("connection reset")
+	impl.refreshEntries(cache)
+	expected := []string{"a.md=@user:\nA", "b.md=@user:\nB", "c.md=@user:\nC"}
+	if got := order(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q after a failure, got %q", expected, got)
+	}
+
+	// Changed templates are reparsed in place and removed ones dropped, without reordering
+	source.statErr = nil
+	source.MockSource = NewMockSource(map[string]string{"a.md": "@user:\nA2", "c.md": "@user:\nC"})
+	impl.refreshEntries(cache)
+	expected = []string{"a.md=@user:\nA2", "c.md=@user:\nC"}
+	if got := order(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q after refresh, got %q", expected, got)
+	}
+}
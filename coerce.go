@@ -0,0 +1,61 @@
+package echotemplates
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// defaultCoercers convert the built-in variable types
+// Values are matched by kind, so named types such as enums are covered as well
+var defaultCoercers = map[reflect.Kind]Coercer{
+	reflect.String: func(v any) (string, bool) {
+		return reflect.ValueOf(v).String(), true
+	},
+	reflect.Int: func(v any) (string, bool) {
+		return strconv.FormatInt(reflect.ValueOf(v).Int(), 10), true
+	},
+	reflect.Int64: func(v any) (string, bool) {
+		return strconv.FormatInt(reflect.ValueOf(v).Int(), 10), true
+	},
+	reflect.Float64: func(v any) (string, bool) {
+		return strconv.FormatFloat(reflect.ValueOf(v).Float(), 'f', -1, 64), true
+	},
+	reflect.Bool: func(v any) (string, bool) {
+		return strconv.FormatBool(reflect.ValueOf(v).Bool()), true
+	},
+	reflect.Slice: func(v any) (string, bool) {
+		list, ok := v.([]string)
+		if !ok {
+			return "", false
+		}
+		return strings.Join(list, defaultListSeparator), true
+	},
+}
+
+// coerceValue converts a value to string
+// Custom coercers are tried first, then []string is joined with separator,
+// then the default coercers are used; anything else becomes an empty string
+func coerceValue(v any, separator string, coercers map[reflect.Kind]Coercer) string {
+	if v == nil {
+		return ""
+	}
+
+	kind := reflect.TypeOf(v).Kind()
+	if coercer, ok := coercers[kind]; ok {
+		if s, ok := coercer(v); ok {
+			return s
+		}
+	}
+
+	if list, ok := v.([]string); ok {
+		return strings.Join(list, separator)
+	}
+
+	if coercer, ok := defaultCoercers[kind]; ok {
+		if s, ok := coercer(v); ok {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,77 @@
+package echotemplates
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type level int
+
+func TestCoerceValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    any
+		expected string
+	}{
+		{"string", "text", "text"},
+		{"int", 42, "42"},
+		{"int64", int64(7), "7"},
+		{"float64", 0.5, "0.5"},
+		{"bool", true, "true"},
+		{"string slice", []string{"a", "b"}, "a;b"},
+		{"named int", level(3), "3"},
+		{"nil", nil, ""},
+		{"unsupported", struct{}{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := coerceValue(tt.value, ";", nil)
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestCustomCoercers(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"test.md": "@user:\nDate: {{date}}, level: {{level}}, note: {{note}}",
+	})
+
+	engine, err := New(Config{
+		Source: source,
+		Coercers: map[reflect.Kind]Coercer{
+			reflect.Struct: func(v any) (string, bool) {
+				if tm, ok := v.(time.Time); ok {
+					return tm.Format("2006-01-02"), true
+				}
+				return "", false
+			},
+			reflect.Int: func(v any) (string, bool) {
+				if l, ok := v.(level); ok {
+					return []string{"low", "mid", "high"}[l], true
+				}
+				return "", false
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	messages, err := engine.Generate("test", map[string]any{
+		"date":  time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC),
+		"level": level(2),
+		"note":  5,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := "Date: 2024-03-15, level: high, note: 5"
+	if messages[0].Content != expected {
+		t.Errorf("Expected %q, got %q", expected, messages[0].Content)
+	}
+}
@@ -0,0 +1,220 @@
+package echotemplates
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// conditionTagRegex matches {{#if expr}}, {{?flag}}, {{else}}, {{/if}} and {{/flag}} tags
+var conditionTagRegex = regexp.MustCompile(`\{\{\s*(?:#if\s+([^}]*?)|\?\s*([\w.-]+)|else|/\s*([\w.-]+))\s*\}\}`)
+
+// comparisonOperators are checked in order, so two-character operators come first
+var comparisonOperators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// conditionFrame tracks one open {{#if}} or {{?flag}} block
+type conditionFrame struct {
+	flag         string
+	parentActive bool
+	result       bool
+	active       bool
+	seenElse     bool
+}
+
+// processConditionals keeps or drops {{#if expr}}...{{else}}...{{/if}} blocks
+// and their {{?flag}}...{{/flag}} shorthand, which tests flag like {{#if flag}}
+// Tags on a line of their own are removed together with the line
+func processConditionals(content string, vars map[string]string, opts GenerateOptions) (string, error) {
+	if !strings.Contains(content, "{{") || !conditionTagRegex.MatchString(content) {
+		return content, nil
+	}
+
+	var b strings.Builder
+	b.Grow(len(content))
+
+	var stack []conditionFrame
+	active := true
+	pos := 0
+	for _, match := range conditionTagRegex.FindAllStringSubmatchIndex(content, -1) {
+		start, end := expandTagLine(content, match[0], match[1])
+		if start < pos {
+			start = pos
+		}
+		if active {
+			b.WriteString(content[pos:start])
+		}
+		pos = end
+
+		switch {
+		case match[2] != -1 || match[4] != -1:
+			expr := ""
+			frame := conditionFrame{parentActive: active}
+			if match[2] != -1 {
+				expr = content[match[2]:match[3]]
+			} else {
+				expr = content[match[4]:match[5]]
+				frame.flag = expr
+			}
+			if active {
+				result, err := evaluateCondition(expr, vars, opts)
+				if err != nil {
+					return "", err
+				}
+				frame.result = result
+			}
+			frame.active = frame.parentActive && frame.result
+			stack = append(stack, frame)
+		case match[6] == -1:
+			if len(stack) == 0 || stack[len(stack)-1].seenElse {
+				return "", &ParseError{Template: "current", Message: "unexpected {{else}}"}
+			}
+			frame := &stack[len(stack)-1]
+			frame.seenElse = true
+			frame.active = frame.parentActive && !frame.result
+		default:
+			// {{/if}} closes {{#if}} blocks, {{/flag}} closes the matching {{?flag}}
+			name := content[match[6]:match[7]]
+			if len(stack) == 0 || !closesFrame(stack[len(stack)-1], name) {
+				return "", &ParseError{Template: "current", Message: fmt.Sprintf("unexpected {{/%s}}", name)}
+			}
+			stack = stack[:len(stack)-1]
+		}
+
+		active = true
+		if len(stack) > 0 {
+			active = stack[len(stack)-1].active
+		}
+	}
+
+	if len(stack) > 0 {
+		if flag := stack[len(stack)-1].flag; flag != "" {
+			return "", &ParseError{Template: "current", Message: fmt.Sprintf("unclosed {{?%s}}", flag)}
+		}
+		return "", &ParseError{Template: "current", Message: "unclosed {{#if}}"}
+	}
+
+	b.WriteString(content[pos:])
+	return b.String(), nil
+}
+
+// closesFrame reports whether {{/name}} closes the frame
+func closesFrame(frame conditionFrame, name string) bool {
+	if frame.flag != "" {
+		return frame.flag == name
+	}
+	return name == "if"
+}
+
+// expandTagLine widens a tag span to the whole line when the tag is alone on it
+func expandTagLine(content string, start, end int) (int, int) {
+	lineStart := strings.LastIndexByte(content[:start], '\n') + 1
+	if strings.TrimLeft(content[lineStart:start], " \t") != "" {
+		return start, end
+	}
+
+	lineEnd := len(content)
+	if idx := strings.IndexByte(content[end:], '\n'); idx != -1 {
+		lineEnd = end + idx + 1
+	}
+	if strings.TrimSpace(content[end:lineEnd]) != "" {
+		return start, end
+	}
+	return lineStart, lineEnd
+}
+
+// evaluateCondition evaluates "name" or "name op value"
+// A bare variable is true unless it is missing, empty, "false" or "0"
+// Comparisons are numeric when both sides are numbers and textual otherwise
+func evaluateCondition(expr string, vars map[string]string, opts GenerateOptions) (bool, error) {
+	expr = strings.TrimSpace(expr)
+
+	name, op, literal := expr, "", ""
+	for _, candidate := range comparisonOperators {
+		if left, right, ok := strings.Cut(expr, candidate); ok {
+			name, op, literal = strings.TrimSpace(left), candidate, strings.TrimSpace(right)
+			break
+		}
+	}
+
+	if name == "" || strings.ContainsAny(name, " \t") || (op != "" && literal == "") {
+		if opts.AllowMissingVars {
+			return false, nil
+		}
+		return false, &ParseError{Template: "current", Message: fmt.Sprintf("invalid condition %q", expr)}
+	}
+
+	value, ok := lookupVar(name, vars, opts)
+	if op == "" {
+		return ok && value != "" && value != "false" && value != "0", nil
+	}
+	if !ok {
+		if opts.AllowMissingVars {
+			return false, nil
+		}
+		return false, &VariableError{Variable: name, Template: "current", MissingVars: []string{name}}
+	}
+
+	if unquoted, err := strconv.Unquote(literal); err == nil {
+		literal = unquoted
+	}
+	return compareValues(value, op, literal), nil
+}
+
+// compareValues applies a comparison operator to two values
+func compareValues(left, op, right string) bool {
+	cmp := strings.Compare(left, right)
+	if l, err := strconv.ParseFloat(left, 64); err == nil {
+		if r, err := strconv.ParseFloat(right, 64); err == nil {
+			switch {
+			case l < r:
+				cmp = -1
+			case l > r:
+				cmp = 1
+			default:
+				cmp = 0
+			}
+		}
+	}
+
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// conditionVariables returns the variable names used by {{#if}} and {{?flag}} tags
+func conditionVariables(content string) []string {
+	var names []string
+	for _, match := range conditionTagRegex.FindAllStringSubmatch(content, -1) {
+		if match[2] != "" {
+			names = append(names, match[2])
+			continue
+		}
+		if match[1] == "" {
+			continue
+		}
+		name := match[1]
+		for _, op := range comparisonOperators {
+			if left, _, ok := strings.Cut(name, op); ok {
+				name = left
+				break
+			}
+		}
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
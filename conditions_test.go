@@ -0,0 +1,158 @@
+package echotemplates
+
+import (
+	"testing"
+)
+
+func TestProcessConditionals(t *testing.T) {
+	vars := map[string]string{
+		"count":  "3",
+		"zero":   "0",
+		"status": "active",
+		"name":   "Ann",
+		"empty":  "",
+		"price":  "9.5",
+	}
+
+	tests := []struct {
+		name        string
+		content     string
+		opts        GenerateOptions
+		expected    string
+		expectError bool
+	}{
+		{"truthy", "A{{#if name}}B{{/if}}C", GenerateOptions{}, "ABC", false},
+		{"falsy empty", "A{{#if empty}}B{{/if}}C", GenerateOptions{}, "AC", false},
+		{"falsy zero", "A{{#if zero}}B{{/if}}C", GenerateOptions{}, "AC", false},
+		{"missing is false", "A{{#if nope}}B{{/if}}C", GenerateOptions{}, "AC", false},
+		{"numeric greater", "{{#if count > 0}}yes{{/if}}", GenerateOptions{}, "yes", false},
+		{"numeric not string compare", "{{#if count < 10}}yes{{else}}no{{/if}}", GenerateOptions{}, "yes", false},
+		{"numeric equal float", "{{#if price == 9.50}}yes{{/if}}", GenerateOptions{}, "yes", false},
+		{"greater or equal", "{{#if count >= 3}}yes{{/if}}", GenerateOptions{}, "yes", false},
+		{"less or equal", "{{#if count <= 2}}yes{{else}}no{{/if}}", GenerateOptions{}, "no", false},
+		{"string equal", "{{#if status == active}}on{{else}}off{{/if}}", GenerateOptions{}, "on", false},
+		{"string not equal", "{{#if status != active}}on{{else}}off{{/if}}", GenerateOptions{}, "off", false},
+		{"quoted string", `{{#if status == "active"}}on{{/if}}`, GenerateOptions{}, "on", false},
+		{"string order", "{{#if name < Bob}}first{{/if}}", GenerateOptions{}, "first", false},
+		{"nested", "{{#if count > 1}}a{{#if zero}}b{{else}}c{{/if}}d{{/if}}", GenerateOptions{}, "acd", false},
+		{"skipped branch not evaluated", "{{#if empty}}{{#if nope > 1}}x{{/if}}{{/if}}ok", GenerateOptions{}, "ok", false},
+		{"own lines", "Start\n{{#if count > 0}}\nItems: {{count}}\n{{else}}\nNo items\n{{/if}}\nEnd", GenerateOptions{}, "Start\nItems: {{count}}\nEnd", false},
+		{"missing comparison", "{{#if nope > 1}}x{{/if}}", GenerateOptions{}, "", true},
+		{"missing comparison allowed", "{{#if nope > 1}}x{{/if}}y", GenerateOptions{AllowMissingVars: true}, "y", false},
+		{"invalid expression", "{{#if count >}}x{{/if}}", GenerateOptions{}, "", true},
+		{"invalid expression allowed", "{{#if count >}}x{{/if}}y", GenerateOptions{AllowMissingVars: true}, "y", false},
+		{"unclosed", "{{#if name}}x", GenerateOptions{}, "", true},
+		{"stray end", "x{{/if}}", GenerateOptions{}, "", true},
+		{"double else", "{{#if name}}a{{else}}b{{else}}c{{/if}}", GenerateOptions{}, "", true},
+		{"no conditionals", "Hello {{name}}", GenerateOptions{}, "Hello {{name}}", false},
+		{"flag present", "A{{?name}}B {{name}}{{/name}}C", GenerateOptions{}, "AB {{name}}C", false},
+		{"flag absent", "A{{?nope}}B{{/nope}}C", GenerateOptions{}, "AC", false},
+		{"flag falsy", "A{{?zero}}B{{/zero}}C", GenerateOptions{}, "AC", false},
+		{"flag else", "{{?empty}}a{{else}}b{{/empty}}", GenerateOptions{}, "b", false},
+		{"nested flags", "{{?name}}a{{?status}}b{{?zero}}c{{/zero}}{{/status}}{{/name}}", GenerateOptions{}, "ab", false},
+		{"flag inside if", "{{#if count > 1}}a{{?name}}b{{/name}}{{/if}}", GenerateOptions{}, "ab", false},
+		{"flag own lines", "Start\n{{?name}}\nHi {{name}}\n{{/name}}\nEnd", GenerateOptions{}, "Start\nHi {{name}}\nEnd", false},
+		{"flag mismatched end", "{{?name}}x{{/status}}", GenerateOptions{}, "", true},
+		{"flag closed by if", "{{?name}}x{{/if}}", GenerateOptions{}, "", true},
+		{"unclosed flag", "{{?name}}x", GenerateOptions{}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := processConditionals(tt.content, vars, tt.opts)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestConditionalTemplates(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"cart.md": "---\ndefault.count: 0\n---\n@user:\n{{#if count > 0}}\nYou have {{count}} items.\n{{else}}\nYour cart is empty.\n{{/if}}\n{{#if status == vip}}\nThanks for being a VIP!\n{{/if}}",
+	})
+
+	engine, err := New(Config{Source: source})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		vars     map[string]any
+		expected string
+	}{
+		{"defaults", map[string]any{"status": "new"}, "Your cart is empty."},
+		{"items", map[string]any{"count": 2, "status": "vip"}, "You have 2 items.\nThanks for being a VIP!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := engine.Generate("cart", tt.vars)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if messages[0].Content != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, messages[0].Content)
+			}
+		})
+	}
+
+	vars, err := engine.GetTemplateVariables("cart")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(vars) != 2 || vars[0] != "count" || vars[1] != "status" {
+		t.Errorf("Expected [count status], got %v", vars)
+	}
+}
+
+func TestFlagTemplates(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"greet.md": "@user:\nHello{{?name}}, {{name}}{{/name}}!\n{{?premium}}\nYour plan: {{plan|gold}}\n{{/premium}}",
+	})
+
+	engine, err := New(Config{Source: source})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		vars     map[string]any
+		expected string
+	}{
+		{"absent", nil, "Hello!"},
+		{"present", map[string]any{"name": "Ann", "premium": true}, "Hello, Ann!\nYour plan: gold"},
+		{"false", map[string]any{"premium": false, "plan": "silver"}, "Hello!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := engine.Generate("greet", tt.vars)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if messages[0].Content != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, messages[0].Content)
+			}
+		})
+	}
+
+	vars, err := engine.GetTemplateVariables("greet")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(vars) != 3 || vars[0] != "name" || vars[1] != "plan" || vars[2] != "premium" {
+		t.Errorf("Expected [name plan premium], got %v", vars)
+	}
+}
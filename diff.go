@@ -0,0 +1,204 @@
+package echotemplates
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// diffContext is the number of unchanged lines shown around changes
+const diffContext = 3
+
+// Diff renders a template with two sets of variables and returns a unified diff
+// of the text outputs (see GenerateText), or an empty string when they are equal
+func (e *templateEngine) Diff(name string, varsA, varsB map[string]any, opts ...GenerateOptions) (string, error) {
+	textA, _, err := e.GenerateText(name, varsA, opts...)
+	if err != nil {
+		return "", err
+	}
+	textB, _, err := e.GenerateText(name, varsB, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	label := e.templateName(e.withExtension(e.resolveAlias(name)))
+	return unifiedDiff(textA, textB, "a/"+label, "b/"+label), nil
+}
+
+// DiffContent renders a template and an alternate version of its text with the same
+// variables and returns a unified diff of the outputs, e.g. to review an edit before saving
+// Imports of the alternate content are resolved from the engine source
+func (e *templateEngine) DiffContent(name, content string, vars map[string]any, opts ...GenerateOptions) (string, error) {
+	current, _, err := e.GenerateText(name, vars, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	path := e.withExtension(e.resolveAlias(name))
+	config := e.config
+	config.Source = &overlaySource{TemplateSource: e.source, path: path, content: content}
+	config.DevMode = false
+	config.PrewarmInterval = 0
+	config.Cache = nil
+	alternate, err := New(config)
+	if err != nil {
+		return "", err
+	}
+	e.partialsMu.RLock()
+	for k, v := range e.partials {
+		alternate.SetPartial(k, v)
+	}
+	e.partialsMu.RUnlock()
+
+	// Never cache the alternate content
+	options := e.config.DefaultOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	options.DisableCache = true
+
+	changed, _, err := alternate.GenerateText(path, vars, options)
+	if err != nil {
+		return "", err
+	}
+
+	label := e.templateName(path)
+	return unifiedDiff(current, changed, "a/"+label, "b/"+label), nil
+}
+
+// overlaySource serves one template from memory and everything else from the wrapped source
+type overlaySource struct {
+	TemplateSource
+	path    string
+	content string
+}
+
+// Open returns the overlay content for its path
+func (s *overlaySource) Open(path string) (io.ReadCloser, error) {
+	if path == s.path {
+		return io.NopCloser(strings.NewReader(s.content)), nil
+	}
+	return s.TemplateSource.Open(path)
+}
+
+// Stat describes the overlay content for its path
+func (s *overlaySource) Stat(path string) (TemplateInfo, error) {
+	if path == s.path {
+		return TemplateInfo{
+			Path:    path,
+			ModTime: time.Now(),
+			Size:    int64(len(s.content)),
+			ETag:    contentETag([]byte(s.content)),
+		}, nil
+	}
+	return s.TemplateSource.Stat(path)
+}
+
+// diffLine is one line of a diff, op is ' ', '-' or '+'
+// a and b count the lines of each side before this one
+type diffLine struct {
+	op   byte
+	text string
+	a, b int
+}
+
+// unifiedDiff returns a line diff of a and b in unified format, empty when they are equal
+func unifiedDiff(a, b, labelA, labelB string) string {
+	if a == b {
+		return ""
+	}
+
+	lines := diffLines(strings.Split(a, "\n"), strings.Split(b, "\n"))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", labelA, labelB)
+
+	for start := 0; start < len(lines); {
+		// Find the next change and extend the hunk while changes are close
+		first := start
+		for first < len(lines) && lines[first].op == ' ' {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+		last := first
+		for i := first; i < len(lines); i++ {
+			if lines[i].op != ' ' {
+				last = i
+			} else if i-last > 2*diffContext {
+				break
+			}
+		}
+
+		from := max(first-diffContext, start)
+		to := min(last+diffContext+1, len(lines))
+		hunk := lines[from:to]
+
+		countA, countB := 0, 0
+		for _, line := range hunk {
+			if line.op != '+' {
+				countA++
+			}
+			if line.op != '-' {
+				countB++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(hunk[0].a, countA), hunkRange(hunk[0].b, countB))
+		for _, line := range hunk {
+			out.WriteByte(line.op)
+			out.WriteString(line.text)
+			out.WriteByte('\n')
+		}
+
+		start = to
+	}
+
+	return out.String()
+}
+
+// hunkRange formats the start,count of a hunk side, lines are numbered from 1
+// and an empty side points at the line before it
+func hunkRange(before, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+// diffLines aligns two line lists by their longest common subsequence
+func diffLines(a, b []string) []diffLine {
+	// lcs[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{op: ' ', text: a[i], a: i, b: j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{op: '-', text: a[i], a: i, b: j})
+			i++
+		default:
+			lines = append(lines, diffLine{op: '+', text: b[j], a: i, b: j})
+			j++
+		}
+	}
+	return lines
+}
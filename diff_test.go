@@ -0,0 +1,92 @@
+package echotemplates
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		expected string
+	}{
+		{"equal", "a\nb", "a\nb", ""},
+		{"changed line", "a\nb\nc", "a\nx\nc", "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"added line", "a\nb", "a\nb\nc", "--- a\n+++ b\n@@ -1,2 +1,3 @@\n a\n b\n+c\n"},
+		{"from empty", "", "a", "--- a\n+++ b\n@@ -1,1 +1,1 @@\n-\n+a\n"},
+		{
+			"separate hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12",
+			"1\nX\n3\n4\n5\n6\n7\n8\n9\n10\nY\n12",
+			"--- a\n+++ b\n@@ -1,5 +1,5 @@\n 1\n-2\n+X\n 3\n 4\n 5\n@@ -8,5 +8,5 @@\n 8\n 9\n 10\n-11\n+Y\n 12\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := unifiedDiff(tt.a, tt.b, "a", "b"); diff != tt.expected {
+				t.Errorf("Expected diff:\n%s\ngot:\n%s", tt.expected, diff)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"support.md":       "@system:\nYou are a {{tone}} assistant.\n{{@shared/rules}}\n\n@user:\n{{question}}",
+		"shared/rules.md":  "Be brief.",
+		"shared/footer.md": "Thanks!",
+		"plain.md":         "Hello {{name}}",
+	})
+
+	engine, err := New(Config{Source: source})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	vars := map[string]any{"tone": "friendly", "question": "Hi"}
+	diff, err := engine.Diff("support", vars, map[string]any{"tone": "formal", "question": "Hi"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(diff, "--- a/support\n+++ b/support\n") ||
+		!strings.Contains(diff, "\n-System: You are a friendly assistant.\n+System: You are a formal assistant.\n") {
+		t.Errorf("Unexpected diff:\n%s", diff)
+	}
+
+	if diff, err := engine.Diff("support", vars, vars); err != nil || diff != "" {
+		t.Errorf("Expected no diff, got %q (%v)", diff, err)
+	}
+
+	// Alternate content is rendered with imports from the source
+	diff, err = engine.DiffContent("support", "@system:\nYou are a {{tone}} assistant.\n{{@shared/rules}}\n{{@shared/footer}}\n\n@user:\n{{question}}", vars)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(diff, "\n Be brief.\n+Thanks!\n") {
+		t.Errorf("Unexpected diff:\n%s", diff)
+	}
+
+	// The alternate content doesn't replace the cached template
+	messages, err := engine.Generate("support", vars)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(messages[0].Content, "Thanks!") {
+		t.Errorf("Expected the original template, got %q", messages[0].Content)
+	}
+
+	// Identical content renders with the same default options
+	withDefaults, err := New(Config{Source: source, DefaultOptions: GenerateOptions{DefaultRole: "system"}})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if diff, err := withDefaults.DiffContent("plain", "Hello {{name}}", map[string]any{"name": "x"}); err != nil || diff != "" {
+		t.Errorf("Expected no diff for identical content, got %q (%v)", diff, err)
+	}
+
+	if _, err := engine.Diff("missing", vars, vars); err == nil {
+		t.Error("Expected error for a missing template")
+	}
+}
@@ -0,0 +1,91 @@
+package echotemplates
+
+import (
+	"path"
+	"slices"
+	"strings"
+)
+
+// dirDefaultsFiles hold front-matter shared by all templates of a directory
+// The first one found in a directory applies, its body is ignored
+var dirDefaultsFiles = []string{"_defaults.md", "_config.md"}
+
+// isDirDefaultsFile reports whether a template path is a directory defaults file
+func isDirDefaultsFile(name string) bool {
+	return slices.Contains(dirDefaultsFiles, path.Base(name))
+}
+
+// directoryMetadata merges the directory defaults of a template,
+// from the source root down to the template directory, nearer ones win
+func (e *templateEngine) directoryMetadata(name string, opts GenerateOptions) (map[string]any, error) {
+	if _, isStringSource := e.source.(*stringSource); isStringSource {
+		return nil, nil
+	}
+
+	var metadata map[string]any
+	for _, dir := range parentDirs(name) {
+		file := e.dirDefaultsFile(dir, opts)
+		if file == "" || file == name {
+			continue
+		}
+
+		defaults, err := e.loadTemplate(file, opts)
+		if err != nil {
+			return nil, err
+		}
+		if metadata == nil {
+			metadata = make(map[string]any)
+		}
+		mergeMetadata(metadata, defaults.metadata)
+	}
+	return metadata, nil
+}
+
+// dirDefaultsFile returns the defaults file of a directory or an empty string
+// Lookups are cached alongside the templates, so new files need ClearCache in production
+func (e *templateEngine) dirDefaultsFile(dir string, opts GenerateOptions) string {
+	cached := e.cacheState() != nil && !opts.DisableCache
+	if cached {
+		e.dirDefaultsMu.RLock()
+		file, ok := e.dirDefaults[dir]
+		e.dirDefaultsMu.RUnlock()
+		if ok {
+			return file
+		}
+	}
+
+	file := ""
+	for _, candidate := range dirDefaultsFiles {
+		candidate = path.Join(dir, candidate)
+		if _, err := e.source.Stat(candidate); err == nil {
+			file = candidate
+			break
+		}
+	}
+
+	if cached {
+		e.dirDefaultsMu.Lock()
+		if e.dirDefaults == nil {
+			e.dirDefaults = make(map[string]string)
+		}
+		e.dirDefaults[dir] = file
+		e.dirDefaultsMu.Unlock()
+	}
+	return file
+}
+
+// parentDirs returns the directories of a template path from the root down,
+// e.g. "a/b/c.md" gives ".", "a", "a/b"
+func parentDirs(name string) []string {
+	dirs := []string{"."}
+	dir := path.Dir(name)
+	if dir == "." || dir == "/" {
+		return dirs
+	}
+
+	parts := strings.Split(strings.Trim(dir, "/"), "/")
+	for i := range parts {
+		dirs = append(dirs, strings.Join(parts[:i+1], "/"))
+	}
+	return dirs
+}
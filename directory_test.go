@@ -0,0 +1,79 @@
+package echotemplates
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDirectoryDefaults(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"_defaults.md":                "---\ntemperature: 0.7\n---",
+		"summarize/_defaults.md":      "---\nmodel: gpt-4o-mini\nmax_tokens: 200\ndefault.length: short\n---\nIgnored body",
+		"summarize/article.md":        "@user:\nSummarize in a {{length}} form",
+		"summarize/long.md":           "---\nmax_tokens: 2000\ndefault.length: long\n---\n@user:\nSummarize in a {{length}} form",
+		"summarize/legal/_config.md":  "---\nmodel: gpt-4\n---",
+		"summarize/legal/contract.md": "@user:\nSummarize the contract",
+		"chat.md":                     "@user:\nHi",
+	})
+
+	engine, err := New(Config{Source: source})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		content  string
+		metadata map[string]any
+	}{
+		{"inherits directory", "summarize/article", "Summarize in a short form", map[string]any{"model": "gpt-4o-mini", "max_tokens": 200, "temperature": 0.7}},
+		{"template overrides", "summarize/long", "Summarize in a long form", map[string]any{"model": "gpt-4o-mini", "max_tokens": 2000, "temperature": 0.7}},
+		{"nested directory", "summarize/legal/contract", "Summarize the contract", map[string]any{"model": "gpt-4", "max_tokens": 200, "temperature": 0.7}},
+		{"root only", "chat", "Hi", map[string]any{"temperature": 0.7}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, metadata, err := engine.GenerateWithMetadata(tt.template, nil, GenerateOptions{StripInternalMetadata: true})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if messages[0].Content != tt.content {
+				t.Errorf("Expected content %q, got %q", tt.content, messages[0].Content)
+			}
+			if !reflect.DeepEqual(metadata, tt.metadata) {
+				t.Errorf("Expected metadata %v, got %v", tt.metadata, metadata)
+			}
+		})
+	}
+
+	// Defaults files are not listed as templates
+	templates, err := engine.ListTemplates()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"chat", "summarize/article", "summarize/legal/contract", "summarize/long"}
+	if !reflect.DeepEqual(templates, expected) {
+		t.Errorf("Expected templates %v, got %v", expected, templates)
+	}
+}
+
+func TestParentDirs(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected []string
+	}{
+		{"main.md", []string{"."}},
+		{"a/main.md", []string{".", "a"}},
+		{"a/b/main.md", []string{".", "a", "a/b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if dirs := parentDirs(tt.name); !reflect.DeepEqual(dirs, tt.expected) {
+				t.Errorf("parentDirs(%q) = %v, want %v", tt.name, dirs, tt.expected)
+			}
+		})
+	}
+}
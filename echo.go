@@ -1,9 +1,30 @@
 package echotemplates
 
 import (
+	"context"
+	"slices"
+	"strings"
+
 	"github.com/mkozhukh/echo"
 )
 
+// Request is a rendered template ready to be sent with an echo client
+type Request struct {
+	Messages []echo.Message
+	Options  []echo.CallOption
+	Metadata map[string]any
+}
+
+// Call sends the request, extra options are applied after the template ones
+func (r Request) Call(ctx context.Context, client echo.Client, opts ...echo.CallOption) (*echo.Response, error) {
+	return client.Call(ctx, r.Messages, append(slices.Clone(r.Options), opts...)...)
+}
+
+// StreamCall sends the request as a streaming call, extra options are applied after the template ones
+func (r Request) StreamCall(ctx context.Context, client echo.Client, opts ...echo.CallOption) (*echo.StreamResponse, error) {
+	return client.StreamCall(ctx, r.Messages, append(slices.Clone(r.Options), opts...)...)
+}
+
 // CallOptions creates echo.CallOption slice from template metadata
 func CallOptions(metadata map[string]any) []echo.CallOption {
 	if metadata == nil {
@@ -30,12 +51,54 @@ func CallOptions(metadata map[string]any) []echo.CallOption {
 	return opts
 }
 
-func Extend(metadata map[string]any, content string) map[string]any {
-	copy := make(map[string]any)
+// stopMetadataKey holds the stop sequences of a template
+const stopMetadataKey = "stop"
+
+// StopSequences returns the stop front-matter value as a list, e.g. from
+// stop: ["\n\n", "END"] or stop: END, or nil when it is not set
+// echo.CallConfig has no stop option, so CallOptions doesn't include them;
+// Encode adds them to the request body
+func StopSequences(metadata map[string]any) []string {
+	switch stop := metadata[stopMetadataKey].(type) {
+	case []string:
+		return stop
+	case string:
+		if stop != "" {
+			return []string{stop}
+		}
+	}
+	return nil
+}
+
+// Extend returns a copy of metadata with the extra keys merged in
+// Extra keys take precedence, metadata itself is not modified
+func Extend(metadata map[string]any, extra map[string]any) map[string]any {
+	copy := make(map[string]any, len(metadata)+len(extra))
 	for k, v := range metadata {
 		copy[k] = v
 	}
-	copy["user_query"] = content
+	for k, v := range extra {
+		copy[k] = v
+	}
 
 	return copy
 }
+
+// ExtendQuery returns a copy of metadata with content stored as "user_query"
+func ExtendQuery(metadata map[string]any, content string) map[string]any {
+	return Extend(metadata, map[string]any{"user_query": content})
+}
+
+// DefaultTextFormatter joins messages as role-prefixed paragraphs
+// e.g. "System: ...\n\nUser: ..."
+func DefaultTextFormatter(messages []echo.Message) string {
+	parts := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		role := msg.Role
+		if role != "" {
+			role = strings.ToUpper(role[:1]) + role[1:]
+		}
+		parts = append(parts, role+": "+msg.Content)
+	}
+	return strings.Join(parts, "\n\n")
+}
@@ -1,6 +1,8 @@
 package echotemplates
 
 import (
+	"context"
+	"reflect"
 	"testing"
 
 	"github.com/mkozhukh/echo"
@@ -211,7 +213,7 @@ func TestExtendData(t *testing.T) {
 		"max_tokens": 4096,
 	}
 
-	ext := Extend(base, "x")
+	ext := ExtendQuery(base, "x")
 
 	if ext["max_tokens"] != 4096 {
 		t.Errorf("Expected max_tokens to be 4096, got %v", ext["max_tokens"])
@@ -225,3 +227,190 @@ func TestExtendData(t *testing.T) {
 		t.Errorf("Expected base not to be updated, got %v", base["1"])
 	}
 }
+
+func TestExtend(t *testing.T) {
+	base := map[string]any{
+		"max_tokens": 4096,
+		"model":      "gpt-4",
+	}
+
+	ext := Extend(base, map[string]any{
+		"model":      "gpt-4o-mini",
+		"user_query": "x",
+		"topic":      "go",
+	})
+
+	expected := map[string]any{
+		"max_tokens": 4096,
+		"model":      "gpt-4o-mini",
+		"user_query": "x",
+		"topic":      "go",
+	}
+	if !reflect.DeepEqual(ext, expected) {
+		t.Errorf("Expected %v, got %v", expected, ext)
+	}
+
+	if base["model"] != "gpt-4" || len(base) != 2 {
+		t.Errorf("Expected base not to be updated, got %v", base)
+	}
+
+	if ext := Extend(nil, nil); ext == nil || len(ext) != 0 {
+		t.Errorf("Expected empty map, got %v", ext)
+	}
+}
+
+func TestDefaultTextFormatter(t *testing.T) {
+	messages := []echo.Message{
+		{Role: echo.System, Content: "Be brief."},
+		{Role: echo.User, Content: "Hi"},
+	}
+
+	expected := "System: Be brief.\n\nUser: Hi"
+	if got := DefaultTextFormatter(messages); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestGenerateRequest(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"chat.md": "---\nmodel: mock/test\ntemperature: 0.4\n---\n@system:\nBe {{tone}}.\n@user:\nHi",
+	})
+
+	engine, err := New(Config{Source: source})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	req, err := engine.GenerateRequest("chat", map[string]any{"tone": "brief"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []echo.Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "Hi"},
+	}
+	if !reflect.DeepEqual(req.Messages, expected) {
+		t.Errorf("Expected messages %v, got %v", expected, req.Messages)
+	}
+
+	var cfg echo.CallConfig
+	for _, opt := range req.Options {
+		opt(&cfg)
+	}
+	if cfg.Model != "mock/test" {
+		t.Errorf("Expected model mock/test, got %q", cfg.Model)
+	}
+	if cfg.Temperature == nil || *cfg.Temperature != 0.4 {
+		t.Errorf("Expected temperature 0.4, got %v", cfg.Temperature)
+	}
+
+	// The request can be sent as is
+	client, err := echo.NewClient("mock/default", "")
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	resp, err := req.Call(context.Background(), client)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Text != "[system]: Be brief.\n[user]: Hi" {
+		t.Errorf("Unexpected response %q", resp.Text)
+	}
+
+	if _, err := engine.GenerateRequest("missing", nil); err == nil {
+		t.Error("Expected error for missing template")
+	}
+}
+
+func TestGenerateWithCallOptions(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"chat.md": "---\nmodel: mock/test\ntemperature: 0.4\nmax_tokens: 200\n---\n@system:\nBe {{tone}}.\n@user:\nHi",
+	})
+
+	engine, err := New(Config{Source: source})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	vars := map[string]any{"tone": "brief"}
+	messages, options, err := engine.GenerateWithOptions("chat", vars)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Same result as GenerateWithMetadata followed by CallOptions
+	expectedMessages, metadata, err := engine.GenerateWithMetadata("chat", vars)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(messages, expectedMessages) {
+		t.Errorf("Expected messages %v, got %v", expectedMessages, messages)
+	}
+
+	var cfg, expectedCfg echo.CallConfig
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	for _, opt := range CallOptions(metadata) {
+		opt(&expectedCfg)
+	}
+	if !reflect.DeepEqual(cfg, expectedCfg) {
+		t.Errorf("Expected call config %+v, got %+v", expectedCfg, cfg)
+	}
+	if cfg.Model != "mock/test" || cfg.Temperature == nil || *cfg.Temperature != 0.4 || cfg.MaxTokens == nil || *cfg.MaxTokens != 200 {
+		t.Errorf("Unexpected call config %+v", cfg)
+	}
+
+	if _, _, err := engine.GenerateWithOptions("missing", nil); err == nil {
+		t.Error("Expected error for missing template")
+	}
+}
+
+func TestStopSequences(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"list.md":   "---\nstop: [\"\\n\\n\", \"END\", 'a, b']\n---\n@user:\nHi",
+		"single.md": "---\nstop: END\n---\n@user:\nHi",
+		"quoted.md": "---\nstop: \"\\n###\"\n---\n@user:\nHi",
+		"toml.md":   "+++\nstop = \"END\"\n+++\n@user:\nHi",
+		"array.md":  "+++\nstop = [\"\\n\\n\", \"END\"]\n+++\n@user:\nHi",
+		"none.md":   "@user:\nHi",
+	})
+
+	engine, err := New(Config{Source: source})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	tests := []struct {
+		template string
+		expected []string
+	}{
+		{"list", []string{"\n\n", "END", "a, b"}},
+		{"single", []string{"END"}},
+		{"quoted", []string{"\n###"}},
+		{"toml", []string{"END"}},
+		{"array", []string{"\n\n", "END"}},
+		{"none", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			_, metadata, err := engine.GenerateWithMetadata(tt.template, nil)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tt.expected != nil && !reflect.DeepEqual(metadata["stop"], tt.expected) {
+				t.Errorf("Expected metadata stop %q, got %#v", tt.expected, metadata["stop"])
+			}
+			if stop := StopSequences(metadata); !reflect.DeepEqual(stop, tt.expected) {
+				t.Errorf("Expected stop sequences %q, got %q", tt.expected, stop)
+			}
+		})
+	}
+
+	// Values set in code may use a plain string
+	if stop := StopSequences(map[string]any{"stop": "END"}); !reflect.DeepEqual(stop, []string{"END"}) {
+		t.Errorf("Expected [END], got %q", stop)
+	}
+}
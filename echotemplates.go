@@ -1,6 +1,10 @@
 package echotemplates
 
 import (
+	"io"
+	"reflect"
+	"time"
+
 	"github.com/mkozhukh/echo"
 )
 
@@ -13,20 +17,131 @@ type TemplateEngine interface {
 	// GenerateWithMetadata creates messages and returns template metadata
 	GenerateWithMetadata(name string, vars map[string]any, opts ...GenerateOptions) ([]echo.Message, map[string]any, error)
 
+	// GenerateConversations creates one message set per conversation of a template,
+	// conversations are separated by @@@ lines, e.g. for few-shot examples
+	GenerateConversations(name string, vars map[string]any, opts ...GenerateOptions) ([][]echo.Message, map[string]any, error)
+
+	// GenerateBlock creates messages from the {{#block name}} of a template only,
+	// e.g. to preview a section; the block keeps the role of the section it is in
+	GenerateBlock(name, block string, vars map[string]any, opts ...GenerateOptions) ([]echo.Message, error)
+
+	// GenerateWithResolver creates messages from a template, asking the resolver
+	// for variables only when the template references them
+	GenerateWithResolver(name string, resolver VarResolver, opts ...GenerateOptions) ([]echo.Message, error)
+
+	// GenerateRequest renders a template into messages and the call options
+	// mapped from its metadata (see CallOptions)
+	GenerateRequest(name string, vars map[string]any, opts ...GenerateOptions) (Request, error)
+
+	// GenerateWithOptions creates messages and the call options mapped from the
+	// template metadata, e.g. client.Call(ctx, messages, options...)
+	GenerateWithOptions(name string, vars map[string]any, opts ...GenerateOptions) ([]echo.Message, []echo.CallOption, error)
+
+	// GenerateText renders a template and joins all messages into a single string
+	// Useful for plain completion endpoints, format is controlled by Config.TextFormatter
+	GenerateText(name string, vars map[string]any, opts ...GenerateOptions) (string, map[string]any, error)
+
+	// GenerateMultimodal renders a template into messages with text and image parts
+	// Images are referenced as {{image:url}} or ![alt](url)
+	GenerateMultimodal(name string, vars map[string]any, opts ...GenerateOptions) ([]MultimodalMessage, map[string]any, error)
+
+	// Diff renders a template with two sets of variables and returns a unified diff
+	// of the text outputs, empty when they are equal
+	Diff(name string, varsA, varsB map[string]any, opts ...GenerateOptions) (string, error)
+
+	// DiffContent returns a unified diff of the text outputs of a template
+	// and an alternate version of its content, rendered with the same variables
+	DiffContent(name, content string, vars map[string]any, opts ...GenerateOptions) (string, error)
+
+	// SetPartial registers a virtual template under path
+	// Virtual templates take precedence over source files when resolving imports
+	SetPartial(path, content string)
+
+	// RemovePartial unregisters a virtual template added by SetPartial
+	RemovePartial(path string)
+
 	// ClearCache removes cached templates (useful for development)
 	ClearCache()
 
+	// ExportCache writes the parsed templates of the built-in cache to w,
+	// so another process can skip parsing with ImportCache
+	ExportCache(w io.Writer) error
+
+	// ImportCache adds templates written by ExportCache to the cache
+	// Imported entries are used until the source reports a newer version
+	ImportCache(r io.Reader) error
+
+	// Snapshot returns an engine reading from an immutable copy of the current
+	// source contents, e.g. for consistent multi-step or batch generations
+	Snapshot() (TemplateEngine, error)
+
+	// Ping checks that the template source is reachable, for health checks
+	Ping() error
+
+	// Close stops background work such as cache refreshing and file watching
+	Close() error
+
+	// SetDevMode switches dev mode at runtime
+	// Enabling drops the cache and starts watching, disabling stops watching and starts a fresh cache
+	SetDevMode(enabled bool)
+
 	// ValidateTemplate checks if a template is valid without generating messages
 	ValidateTemplate(name string) error
 
+	// ValidateAll validates every template and returns all errors joined
+	ValidateAll() error
+
+	// RawTemplate returns the unparsed template text, front-matter included
+	// If name doesn't contain .md suffix, it will be added automatically
+	RawTemplate(name string) (string, error)
+
+	// LintTemplate reports potential problems that don't prevent generation,
+	// e.g. {{var}} placeholders in @system: sections (LintSystemPlaceholder)
+	LintTemplate(name string) ([]LintIssue, error)
+
+	// GetTemplateVersion returns the version front-matter key of a template
+	// or an empty string when the template doesn't declare one
+	GetTemplateVersion(name string) (string, error)
+
+	// Fingerprint returns a stable hash of a template and its transitive imports,
+	// which changes whenever one of these files changes
+	Fingerprint(name string) (string, error)
+
+	// Inspect returns metadata, variables with their defaults, transitive imports,
+	// size and validation warnings of a template in one report
+	Inspect(name string) (*TemplateReport, error)
+
+	// VariablesJSONSchema describes the variables of a template as a JSON Schema object,
+	// e.g. to generate input forms
+	VariablesJSONSchema(name string) ([]byte, error)
+
+	// GetRegions returns the {{#region Name}}...{{#endregion}} spans of a template
+	// with their file lines; the markers themselves are removed from the output
+	GetRegions(name string) ([]Region, error)
+
+	// GetUnusedImports returns imports that only appear in {{#if}} branches
+	// never taken with the template defaults, e.g. to prune prompt libraries
+	GetUnusedImports(name string) ([]string, error)
+
 	// GetTemplateVariables returns all variable names used in a template
 	GetTemplateVariables(name string) ([]string, error)
 
+	// AllTemplateVariables returns variables of every template keyed by template name
+	AllTemplateVariables() (map[string][]string, error)
+
 	// TemplateExists checks if a template file exists
 	TemplateExists(name string) bool
 
 	// ListTemplates returns all available template paths relative to RootDir
 	ListTemplates() ([]string, error)
+
+	// ListTemplatesByMetadata returns templates whose metadata key equals value
+	// For list values such as tags: [a, b], templates whose list contains value are returned
+	ListTemplatesByMetadata(key string, value any) ([]string, error)
+
+	// FindTemplates returns template paths matching a glob pattern
+	// Supports path.Match syntax per segment and ** for any number of directories
+	FindTemplates(pattern string) ([]string, error)
 }
 
 // GenerateOptions configures template generation behavior
@@ -34,14 +149,111 @@ type GenerateOptions struct {
 	// AllowMissingVars determines if missing placeholders cause errors
 	AllowMissingVars bool
 
+	// FailOnFirstMissing reports only the first missing variable
+	// instead of collecting all of them
+	FailOnFirstMissing bool
+
 	// StrictMode enables strict parsing (no undefined imports, etc)
 	StrictMode bool
 
+	// StrictFilters rejects placeholders ending in an unknown filter, e.g.
+	// {{tags|jion:", "}} or {{name|uppr}}, with a ParseError instead of using the text as the default
+	// Any final "|word" or "|word:arg" segment counts as a filter, so single word
+	// inline defaults like {{role|helpful}} are rejected too
+	StrictFilters bool
+
+	// DisableDynamicImports rejects imports whose path contains variables,
+	// e.g. {{@styles/{{style}}}}, so untrusted input can't select templates
+	// They fail in strict mode and are left unexpanded otherwise
+	DisableDynamicImports bool
+
+	// AllowLiteralImports keeps {{@...}} as literal text in string templates
+	// instead of failing, e.g. for content with {{@mention}} (default: false)
+	AllowLiteralImports bool
+
 	// DisableCache bypasses cache for this generation
 	DisableCache bool
+
+	// MaxMessages limits the number of generated messages (0 means no limit),
+	// for GenerateConversations the messages of all conversations are counted
+	MaxMessages int
+
+	// DefaultRole is the role of the single message created for templates
+	// without role markers (default: "user")
+	DefaultRole string
+
+	// DropEmptyMessages removes messages whose content is blank after substitution
+	// Blank role sections are always skipped, so this drops the default role message
+	// of content without role markers that ends up blank
+	DropEmptyMessages bool
+
+	// Trace adds a _trace entry to the returned metadata, a []TraceSpan mapping
+	// ranges of message content back to template files and lines, across imports
+	Trace bool
+
+	// EstimateTokens adds an _estimated_tokens entry to the returned metadata
+	EstimateTokens bool
+
+	// StripInternalMetadata removes engine-internal keys such as "defaults", "fallbacks"
+	// and "variants" from the returned metadata
+	StripInternalMetadata bool
+
+	// Variant selects a named entry of the front-matter variants map
+	// whose metadata overrides the base metadata
+	Variant string
+
+	// Locale prefers localized templates and imports, e.g. "fr" loads greeting.fr.md
+	// when it exists and greeting.md otherwise; "fr-CA" tries greeting.fr-CA.md first
+	// Values other than language tags such as fr, fr-CA or zh_Hant fail the generation
+	Locale string
+
+	// ListSeparator joins []string values (default: ",")
+	// Use {{var|join:sep}} to override it for a single placeholder
+	ListSeparator string
+
+	// SanitizeVars keeps variable values from changing the structure of the prompt:
+	// role markers such as @system: and {{@import}} or {{placeholder}} sigils in values
+	// end up as literal text instead of starting a message (default: false)
+	// Enable it whenever values come from untrusted input
+	SanitizeVars bool
+
+	// VarTransform preprocesses every resolved {{variable}} value before substitution
+	// Raw {{{variable}}} placeholders are not transformed
+	VarTransform func(name, value string) string
+
+	// Now overrides the clock used by {{now:layout}} placeholders
+	// If nil, Config.Clock or time.Now is used; the clock is read once per generation
+	Now func() time.Time
+
+	// resolver is set by GenerateWithResolver
+	resolver VarResolver
+
+	// fallbacks are the front-matter fallback.<var> values of the template
+	fallbacks map[string]string
+
+	// render are the front-matter render.<var> modes of the template
+	render map[string]string
+
+	// conversations renders each @@@-separated part as its own message set, see GenerateConversations
+	conversations bool
+
+	// block limits generation to the named {{#block}}, see GenerateBlock
+	block string
+
+	// images keeps {{image:url}} references for GenerateMultimodal
+	images bool
 }
 
+// Coercer converts a variable value to a string
+// It returns false if it can't handle the value
+type Coercer func(v any) (string, bool)
+
+// MessagePostProcessor transforms the messages of a generation, see Config.MessagePostProcessors
+type MessagePostProcessor func(messages []echo.Message) ([]echo.Message, error)
+
 // Config configures the template engine
+// A manifest.yaml or .echo-templates.yaml at the source root can provide
+// Aliases, GlobalVars, AllowedRoles and required metadata; explicit Config values win
 type Config struct {
 	// Source is the template source (required)
 	Source TemplateSource
@@ -54,4 +266,135 @@ type Config struct {
 
 	// CacheSize maximum number of templates to cache in production mode (default: 100)
 	CacheSize int
+
+	// CacheCheckInterval is how long the built-in cache uses a template without checking
+	// the source for changes; negative checks on every use (default: 5s)
+	CacheCheckInterval time.Duration
+
+	// FrontMatterDelimiter is the line that opens and closes the front-matter block,
+	// e.g. "~~~" for content from other tooling (default: "---")
+	// Lines starting with # inside the block are comments with any delimiter
+	// A +++ fence always starts a TOML block, so "+++" is rejected by New
+	FrontMatterDelimiter string
+
+	// ImportConcurrency loads up to this many sibling imports of a template in parallel,
+	// e.g. for remote sources; output is the same as with sequential loading
+	// (default: 0, sequential)
+	ImportConcurrency int
+
+	// DisableAutoExtension uses template names and import paths verbatim instead of
+	// appending .md to names without it, for sources with other naming (default: false)
+	// ListTemplates and FindTemplates then include files without an extension
+	// from sources implementing ExtensionlessLister, as the built-in ones do
+	DisableAutoExtension bool
+
+	// GenerateTimeout bounds each generation, including loading templates and imports
+	// from the source; a timed out generation returns an error wrapping
+	// context.DeadlineExceeded (default: 0, no timeout)
+	// Source calls can't be interrupted, so the timed out generation keeps running in
+	// a goroutine until the pending source call returns, then stops before the next import;
+	// a source that never returns leaks that goroutine
+	GenerateTimeout time.Duration
+
+	// DynamicImportAllowlist restricts the templates a dynamic import such as
+	// {{@personas/{{persona}}}} may resolve to, as path.Match glob patterns like
+	// "personas/*"; other paths fail like a missing import (default: none, any path)
+	// Patterns match the import path after variable substitution and before the source
+	// resolves it, so imports found in import directories match by their relative path
+	DynamicImportAllowlist []string
+
+	// MaxTemplateSize rejects template and import files larger than this many bytes
+	// with a TemplateSizeError before they are read (default: 0, unlimited)
+	MaxTemplateSize int64
+
+	// Cache stores parsed templates in production mode (default: built-in LRU of CacheSize)
+	// PrewarmInterval only refreshes the built-in cache
+	Cache Cache
+
+	// FlattenImports caches the import-resolved content of templates, so later
+	// generations skip import resolution until the template or an import changes
+	// Templates with dynamic, glob imports or virtual partials, and generations with a Locale,
+	// are always resolved, glob imports list the source each time (default: false)
+	FlattenImports bool
+
+	// PrewarmInterval enables a background refresher that re-checks cached
+	// templates at this interval and reparses changed ones (default: 0, disabled)
+	// Call Close to stop it
+	PrewarmInterval time.Duration
+
+	// TokenCounter counts tokens in a text; when set, token estimation is always enabled
+	// If nil, a characters/4 heuristic is used for GenerateOptions.EstimateTokens
+	TokenCounter func(string) int
+
+	// RelativeImports resolves imports starting with ./ or ../ against
+	// the directory of the importing template (default: false)
+	RelativeImports bool
+
+	// StripHTMLComments removes <!-- ... --> comments from the template body
+	// so authoring notes don't reach the model (default: false)
+	StripHTMLComments bool
+
+	// OnReload is called in dev mode after watched templates change,
+	// with the changed paths as reported by the source
+	OnReload func(paths []string)
+
+	// PreProcess transforms the raw template text before it is parsed
+	// The transformed result is what gets cached
+	PreProcess func(name, content string) (string, error)
+
+	// DefaultMetadata is applied beneath each template's front-matter,
+	// e.g. a house model or temperature (default: none)
+	// A _defaults.md or _config.md file provides front-matter for all templates of its directory
+	// Precedence: DefaultMetadata < directory defaults < front-matter < GenerateOptions.Variant
+	DefaultMetadata map[string]any
+
+	// MetadataSchema is checked by ValidateTemplate and ValidateAll (default: none)
+	MetadataSchema *MetadataSchema
+
+	// StrictMetadata makes ValidateTemplate and ValidateAll report unknown front-matter keys
+	// Known keys are model, temperature, max_tokens, description, version and KnownMetadataKeys
+	StrictMetadata bool
+
+	// KnownMetadataKeys lists additional front-matter keys accepted by StrictMetadata
+	KnownMetadataKeys []string
+
+	// Aliases map short names to template paths, for Generate and imports
+	// e.g. {"greet": "greetings/hello"}
+	Aliases map[string]string
+
+	// AllowedRoles lists the accepted @role: markers, e.g. "system", "user", "developer"
+	// When set, roles are kept exactly as written and sections with other roles
+	// are skipped, or rejected with a ParseError in strict mode
+	// (default: empty, only system, user and agent are recognized, in any case)
+	AllowedRoles []string
+
+	// Coercers convert variable values of the given kind to strings
+	// They take precedence over the built-in conversions for string, int, int64,
+	// float64, bool and []string; a coercer returning false falls back to them
+	Coercers map[reflect.Kind]Coercer
+
+	// GlobalVars are available to every template
+	// Template defaults and call vars take precedence over them
+	GlobalVars map[string]any
+
+	// PartialsDir holds templates meant only for imports, e.g. "_partials"
+	// They can be imported and generated but are left out of ListTemplates,
+	// FindTemplates and ValidateAll (default: empty, every template is listed)
+	PartialsDir string
+
+	// MetadataAsVars makes front-matter values such as model available as variables,
+	// e.g. "You are powered by {{model}}"; defaults and call vars take precedence
+	// Engine keys like defaults or validate and nested tables are not exposed
+	MetadataAsVars bool
+
+	// MessagePostProcessors run in order over the parsed messages of every generation,
+	// e.g. TrimMessages or MergeSameRole, or provider-specific adjustments
+	// An error fails the generation; trace spans refer to the unprocessed messages
+	MessagePostProcessors []MessagePostProcessor
+
+	// TextFormatter joins messages for GenerateText (default: DefaultTextFormatter)
+	TextFormatter func([]echo.Message) string
+
+	// Clock provides the current time for {{now:layout}} placeholders (default: time.Now)
+	Clock func() time.Time
 }
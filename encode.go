@@ -0,0 +1,105 @@
+package echotemplates
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mkozhukh/echo"
+)
+
+// Formats supported by Encode
+const (
+	FormatOpenAI    = "openai"
+	FormatAnthropic = "anthropic"
+)
+
+// anthropicDefaultMaxTokens is used when metadata has no max_tokens, the API requires it
+const anthropicDefaultMaxTokens = 4096
+
+// Encode serializes messages into the JSON request body of a provider API
+// Model, temperature and max_tokens are taken from metadata as in CallOptions,
+// stop sequences as in StopSequences
+// FormatOpenAI targets chat completions, FormatAnthropic the messages API
+func Encode(messages []echo.Message, metadata map[string]any, format string) ([]byte, error) {
+	var cfg echo.CallConfig
+	for _, opt := range CallOptions(metadata) {
+		opt(&cfg)
+	}
+
+	stop := StopSequences(metadata)
+	switch format {
+	case FormatOpenAI:
+		return json.Marshal(openAIBody{encodeOpenAI(messages, cfg), stop})
+	case FormatAnthropic:
+		body, err := encodeAnthropic(messages, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(anthropicBody{body, stop})
+	default:
+		return nil, fmt.Errorf("unsupported encode format %q", format)
+	}
+}
+
+// openAIBody adds the stop sequences, which echo.OpenAIRequest doesn't have
+type openAIBody struct {
+	echo.OpenAIRequest
+	Stop []string `json:"stop,omitempty"`
+}
+
+// anthropicBody adds the stop sequences, which echo.AnthropicRequest doesn't have
+type anthropicBody struct {
+	echo.AnthropicRequest
+	StopSequences []string `json:"stop_sequences,omitempty"`
+}
+
+// encodeOpenAI keeps system messages in place, agent becomes assistant
+// and other roles such as developer are passed through
+func encodeOpenAI(messages []echo.Message, cfg echo.CallConfig) echo.OpenAIRequest {
+	body := echo.OpenAIRequest{
+		Model:       cfg.Model,
+		Temperature: cfg.Temperature,
+		MaxTokens:   cfg.MaxTokens,
+		Messages:    make([]echo.OpenAIMessage, 0, len(messages)),
+	}
+	for _, msg := range messages {
+		role := msg.Role
+		if role == echo.Agent {
+			role = "assistant"
+		}
+		body.Messages = append(body.Messages, echo.OpenAIMessage{Role: role, Content: msg.Content})
+	}
+	return body
+}
+
+// encodeAnthropic moves system messages to the top-level system field
+// Only user and assistant turns are accepted by the messages API
+func encodeAnthropic(messages []echo.Message, cfg echo.CallConfig) (echo.AnthropicRequest, error) {
+	body := echo.AnthropicRequest{
+		Model:       cfg.Model,
+		MaxTokens:   anthropicDefaultMaxTokens,
+		Temperature: cfg.Temperature,
+		Messages:    make([]echo.AnthropicMessage, 0, len(messages)),
+	}
+	if cfg.MaxTokens != nil {
+		body.MaxTokens = *cfg.MaxTokens
+	}
+
+	var system []string
+	for i, msg := range messages {
+		switch msg.Role {
+		case echo.System:
+			system = append(system, msg.Content)
+		case echo.User:
+			body.Messages = append(body.Messages, echo.AnthropicMessage{Role: "user", Content: msg.Content})
+		case echo.Agent, "assistant":
+			body.Messages = append(body.Messages, echo.AnthropicMessage{Role: "assistant", Content: msg.Content})
+		default:
+			return echo.AnthropicRequest{}, fmt.Errorf("role %q at position %d is not supported by the anthropic format", msg.Role, i)
+		}
+	}
+	body.System = strings.Join(system, "\n\n")
+
+	return body, nil
+}
@@ -0,0 +1,92 @@
+package echotemplates
+
+import (
+	"testing"
+
+	"github.com/mkozhukh/echo"
+)
+
+func TestEncode(t *testing.T) {
+	messages := []echo.Message{
+		{Role: echo.System, Content: "You are helpful."},
+		{Role: echo.User, Content: "Hi"},
+		{Role: echo.Agent, Content: "Hello!"},
+		{Role: echo.User, Content: "What is Go?"},
+	}
+	metadata := map[string]any{"model": "gpt-4", "temperature": 0.5, "max_tokens": 100, "description": "ignored"}
+
+	tests := []struct {
+		name     string
+		messages []echo.Message
+		metadata map[string]any
+		format   string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "openai",
+			messages: messages,
+			metadata: metadata,
+			format:   FormatOpenAI,
+			expected: `{"model":"gpt-4","temperature":0.5,"max_completion_tokens":100,"messages":[{"role":"system","content":"You are helpful."},{"role":"user","content":"Hi"},{"role":"assistant","content":"Hello!"},{"role":"user","content":"What is Go?"}]}`,
+		},
+		{
+			name:     "anthropic",
+			messages: messages,
+			metadata: metadata,
+			format:   FormatAnthropic,
+			expected: `{"model":"gpt-4","messages":[{"role":"user","content":"Hi"},{"role":"assistant","content":"Hello!"},{"role":"user","content":"What is Go?"}],"max_tokens":100,"temperature":0.5,"system":"You are helpful."}`,
+		},
+		{
+			name:     "openai without metadata",
+			messages: []echo.Message{{Role: "developer", Content: "Be brief."}, {Role: echo.User, Content: "Hi"}},
+			format:   FormatOpenAI,
+			expected: `{"model":"","messages":[{"role":"developer","content":"Be brief."},{"role":"user","content":"Hi"}]}`,
+		},
+		{
+			name:     "anthropic default max tokens and joined system",
+			messages: []echo.Message{{Role: echo.System, Content: "A"}, {Role: echo.System, Content: "B"}, {Role: echo.User, Content: "Hi"}},
+			metadata: map[string]any{"model": "claude-sonnet"},
+			format:   FormatAnthropic,
+			expected: `{"model":"claude-sonnet","messages":[{"role":"user","content":"Hi"}],"max_tokens":4096,"system":"A\n\nB"}`,
+		},
+		{
+			name:     "openai stop sequences",
+			messages: []echo.Message{{Role: echo.User, Content: "Hi"}},
+			metadata: map[string]any{"model": "gpt-4", "stop": []string{"\n\n", "END"}},
+			format:   FormatOpenAI,
+			expected: `{"model":"gpt-4","messages":[{"role":"user","content":"Hi"}],"stop":["\n\n","END"]}`,
+		},
+		{
+			name:     "anthropic stop sequences",
+			messages: []echo.Message{{Role: echo.User, Content: "Hi"}},
+			metadata: map[string]any{"model": "claude-sonnet", "stop": "END"},
+			format:   FormatAnthropic,
+			expected: `{"model":"claude-sonnet","messages":[{"role":"user","content":"Hi"}],"max_tokens":4096,"stop_sequences":["END"]}`,
+		},
+		{
+			name:     "anthropic unsupported role",
+			messages: []echo.Message{{Role: "developer", Content: "Be brief."}},
+			format:   FormatAnthropic,
+			wantErr:  true,
+		},
+		{
+			name:     "unknown format",
+			messages: messages,
+			format:   "gemini",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Encode(tt.messages, tt.metadata, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Encode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(data) != tt.expected {
+				t.Errorf("Encode() =\n%s\nwant\n%s", data, tt.expected)
+			}
+		})
+	}
+}
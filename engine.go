@@ -1,21 +1,56 @@
 package echotemplates
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/mkozhukh/echo"
 )
 
 // templateEngine is the main implementation of TemplateEngine
 type templateEngine struct {
-	config    Config
-	source    TemplateSource
-	cache     *templateCache
+	config Config
+	source TemplateSource
+
+	// mu guards the fields below, which change when dev mode is toggled
+	mu        sync.RWMutex
+	cache     Cache
 	watchChan <-chan string
 	devMode   bool
+
+	// stopRefresh stops the background cache refresher
+	stopRefresh chan struct{}
+	closeOnce   sync.Once
+
+	// partials holds virtual templates registered with SetPartial
+	partialsMu sync.RWMutex
+	partials   map[string]string
+
+	// importPaths memoizes expanded dynamic import paths, see expandImportPath
+	importPathsMu sync.RWMutex
+	importPaths   map[string]string
+
+	// variables memoizes GetTemplateVariables results by template path
+	variablesMu sync.Mutex
+	variables   map[string]templateVariables
+
+	// dirDefaults caches the directory defaults file of each directory, "" when missing
+	dirDefaultsMu sync.RWMutex
+	dirDefaults   map[string]string
 }
 
 // New creates a new template engine
@@ -25,41 +60,241 @@ func New(config Config) (TemplateEngine, error) {
 		return nil, fmt.Errorf("config.Source is required")
 	}
 
+	// Merge the library manifest under the explicit config
+	if _, isStringSource := config.Source.(*stringSource); !isStringSource {
+		manifest, err := loadManifest(config.Source)
+		if err != nil {
+			return nil, err
+		}
+		if manifest != nil {
+			config = applyManifest(config, manifest)
+		}
+	}
+
+	// A +++ fence always starts a TOML block, so it can't fence the default syntax
+	if config.FrontMatterDelimiter == tomlFrontMatterDelimiter {
+		return nil, fmt.Errorf("FrontMatterDelimiter %q is reserved for TOML front-matter", tomlFrontMatterDelimiter)
+	}
+
+	for _, pattern := range config.DynamicImportAllowlist {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid DynamicImportAllowlist pattern %q: %w", pattern, err)
+		}
+	}
+
 	// Set defaults
 	if config.CacheSize == 0 {
 		config.CacheSize = 100
 	}
 
 	engine := &templateEngine{
-		config:  config,
-		source:  config.Source,
-		devMode: config.DevMode,
-	}
-
-	// Initialize cache in production mode
-	if !config.DevMode {
-		engine.cache = newTemplateCache(config.CacheSize)
+		config:      config,
+		source:      config.Source,
+		devMode:     config.DevMode,
+		stopRefresh: make(chan struct{}),
 	}
 
+	// The cache is created on first use in production mode
 	// Start file watching in dev mode
 	if config.DevMode {
-		watchChan, err := config.Source.Watch()
-		if err == nil && watchChan != nil {
-			engine.watchChan = watchChan
-			go engine.handleFileChanges()
-		}
+		engine.startWatching()
+	}
+
+	// Keep cached templates fresh in the background
+	if config.PrewarmInterval > 0 {
+		go engine.refreshCache(config.PrewarmInterval)
 	}
 
 	return engine, nil
 }
 
+// Ping checks that the template source is reachable
+// Sources implementing Pinger are asked directly, others list their templates
+func (e *templateEngine) Ping() error {
+	if err := pingSource(e.source); err != nil {
+		return fmt.Errorf("template source unreachable: %w", err)
+	}
+	return nil
+}
+
+// Close stops background work: the cache refresher and file watching
+func (e *templateEngine) Close() error {
+	e.closeOnce.Do(func() {
+		close(e.stopRefresh)
+	})
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.watchChan != nil {
+		e.watchChan = nil
+		return e.source.StopWatch()
+	}
+	return nil
+}
+
+// refreshCache periodically re-checks cached templates and reparses changed ones,
+// so serving goroutines rarely pay the parse cost
+func (e *templateEngine) refreshCache(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.stopRefresh:
+			return
+		case <-ticker.C:
+			// Only the built-in cache knows its keys
+			cache, ok := e.cacheState().(*templateCache)
+			if !ok {
+				continue
+			}
+
+			e.refreshEntries(cache)
+		}
+	}
+}
+
+// refreshEntries compares cached templates with the source and reparses changed ones
+// in place, without changing the LRU order; entries are only dropped when their
+// template is gone, so a temporarily failing source keeps the cache warm
+func (e *templateEngine) refreshEntries(cache *templateCache) {
+	for _, item := range cache.items() {
+		path := item.key
+		// Flattened entries are checked when they are used
+		if isFlattenedKey(path) {
+			continue
+		}
+
+		info, err := e.source.Stat(path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				cache.Invalidate(path)
+			}
+			continue
+		}
+		if !item.template.isStale(info.ModTime, info.ETag) {
+			cache.checked(path)
+			continue
+		}
+
+		data, err := e.readTemplate(path, info)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				cache.Invalidate(path)
+			}
+			continue
+		}
+		template, err := e.parseTemplate(path, string(data))
+		if err != nil || cacheDisabled(template.metadata) {
+			cache.Invalidate(path)
+			continue
+		}
+		cache.refresh(path, newCachedTemplate(template, info.ModTime, info.ETag))
+	}
+}
+
+// startWatching subscribes to source changes, caller must hold mu or own the engine
+func (e *templateEngine) startWatching() {
+	watchChan, err := e.source.Watch()
+	if err == nil && watchChan != nil {
+		e.watchChan = watchChan
+		go e.handleFileChanges(watchChan)
+	}
+}
+
 // handleFileChanges monitors file changes in dev mode
-func (e *templateEngine) handleFileChanges() {
-	for range e.watchChan {
+func (e *templateEngine) handleFileChanges(watchChan <-chan string) {
+	for path := range watchChan {
+		// Changes reported together are passed to OnReload as one batch
+		paths := []string{path}
+	collect:
+		for {
+			select {
+			case path, ok := <-watchChan:
+				if !ok {
+					break collect
+				}
+				if !slices.Contains(paths, path) {
+					paths = append(paths, path)
+				}
+			default:
+				break collect
+			}
+		}
+
 		// Clear entire cache in dev mode when any file changes
 		// This ensures imports are also refreshed
 		e.ClearCache()
+
+		if e.config.OnReload != nil {
+			e.config.OnReload(paths)
+		}
+	}
+}
+
+// SetDevMode switches between development and production mode at runtime
+func (e *templateEngine) SetDevMode(enabled bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.devMode == enabled {
+		return
+	}
+
+	e.devMode = enabled
+	if enabled {
+		// Drop the cache and start watching for changes
+		e.cache = nil
+		e.startWatching()
+		return
+	}
+
+	// Stop watching, a fresh cache is created on first use
+	if e.watchChan != nil {
+		e.source.StopWatch()
+		e.watchChan = nil
+	}
+	e.cache = nil
+}
+
+// newCache returns Config.Cache or a fresh built-in LRU cache
+func (e *templateEngine) newCache() Cache {
+	if e.config.Cache != nil {
+		return e.config.Cache
+	}
+	cache := newTemplateCache(e.config.CacheSize)
+	if e.config.CacheCheckInterval != 0 {
+		cache.checkFreq = e.config.CacheCheckInterval
+	}
+	return cache
+}
+
+// cacheState returns the cache to use, or nil when caching is disabled
+// The cache is created lazily, so swapping it only needs to reset e.cache under mu
+func (e *templateEngine) cacheState() Cache {
+	e.mu.RLock()
+	cache, devMode := e.cache, e.devMode
+	e.mu.RUnlock()
+
+	if devMode {
+		return nil
+	}
+	if cache != nil {
+		return cache
 	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Another goroutine may have switched modes or created the cache meanwhile
+	if e.devMode {
+		return nil
+	}
+	if e.cache == nil {
+		e.cache = e.newCache()
+	}
+	return e.cache
 }
 
 // Generate creates messages from a template
@@ -81,18 +316,182 @@ func (e *templateEngine) GenerateWithMetadata(name string, vars map[string]any,
 	return e.generateInternal(name, vars, options)
 }
 
+// GenerateBlock creates messages from a single named block of a template
+func (e *templateEngine) GenerateBlock(name, block string, vars map[string]any, opts ...GenerateOptions) ([]echo.Message, error) {
+	options := e.config.DefaultOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	if strings.TrimSpace(block) == "" {
+		return nil, fmt.Errorf("block name is required")
+	}
+	options.block = strings.TrimSpace(block)
+	messages, _, err := e.generateInternal(name, vars, options)
+	return messages, err
+}
+
+// GenerateConversations creates one message set per @@@-separated conversation of a template
+func (e *templateEngine) GenerateConversations(name string, vars map[string]any, opts ...GenerateOptions) ([][]echo.Message, map[string]any, error) {
+	options := e.config.DefaultOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	// Trace spans index a single message list
+	options.Trace = false
+
+	options.conversations = true
+	return e.generateBounded(name, vars, options)
+}
+
+// GenerateWithResolver creates messages from a template, resolving variables lazily
+func (e *templateEngine) GenerateWithResolver(name string, resolver VarResolver, opts ...GenerateOptions) ([]echo.Message, error) {
+	options := e.config.DefaultOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	options.resolver = newCachingResolver(resolver)
+	messages, _, err := e.generateInternal(name, nil, options)
+	return messages, err
+}
+
+// GenerateRequest renders a template into messages and call options
+func (e *templateEngine) GenerateRequest(name string, vars map[string]any, opts ...GenerateOptions) (Request, error) {
+	options := e.config.DefaultOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	messages, metadata, err := e.generateInternal(name, vars, options)
+	if err != nil {
+		return Request{}, err
+	}
+
+	return Request{
+		Messages: messages,
+		Options:  CallOptions(metadata),
+		Metadata: metadata,
+	}, nil
+}
+
+// GenerateWithOptions renders a template into messages and the call options
+// mapped from its metadata, for callers that don't need the metadata itself
+func (e *templateEngine) GenerateWithOptions(name string, vars map[string]any, opts ...GenerateOptions) ([]echo.Message, []echo.CallOption, error) {
+	messages, metadata, err := e.GenerateWithMetadata(name, vars, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return messages, CallOptions(metadata), nil
+}
+
+// GenerateText renders a template and joins all messages into a single string
+func (e *templateEngine) GenerateText(name string, vars map[string]any, opts ...GenerateOptions) (string, map[string]any, error) {
+	options := e.config.DefaultOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	messages, metadata, err := e.generateInternal(name, vars, options)
+	if err != nil {
+		return "", nil, err
+	}
+
+	formatter := e.config.TextFormatter
+	if formatter == nil {
+		formatter = DefaultTextFormatter
+	}
+	return formatter(messages), metadata, nil
+}
+
+// GenerateMultimodal creates messages split into text and image content parts
+func (e *templateEngine) GenerateMultimodal(name string, vars map[string]any, opts ...GenerateOptions) ([]MultimodalMessage, map[string]any, error) {
+	options := e.config.DefaultOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	options.images = true
+	messages, metadata, err := e.generateInternal(name, vars, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return MultimodalMessages(messages), metadata, nil
+}
+
 // ClearCache removes cached templates
 func (e *templateEngine) ClearCache() {
-	if e.cache != nil {
-		e.cache.clear()
+	e.dirDefaultsMu.Lock()
+	e.dirDefaults = nil
+	e.dirDefaultsMu.Unlock()
+
+	e.importPathsMu.Lock()
+	e.importPaths = nil
+	e.importPathsMu.Unlock()
+
+	e.variablesMu.Lock()
+	e.variables = nil
+	e.variablesMu.Unlock()
+
+	// Don't create a cache just to clear it
+	e.mu.RLock()
+	cache := e.cache
+	e.mu.RUnlock()
+
+	if cache != nil {
+		cache.Clear()
 	}
 }
 
-// generateInternal is the core generation logic
+// generateResult carries the outcome of a generation run under Config.GenerateTimeout
+type generateResult struct {
+	conversations [][]echo.Message
+	metadata      map[string]any
+	err           error
+}
+
+// generateInternal runs a generation and returns the messages of all its conversations
 func (e *templateEngine) generateInternal(name string, vars map[string]any, opts GenerateOptions) ([]echo.Message, map[string]any, error) {
+	conversations, metadata, err := e.generateBounded(name, vars, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return slices.Concat(conversations...), metadata, nil
+}
+
+// generateBounded runs generate, bounded by Config.GenerateTimeout when set
+// Sources can't be interrupted, so a timed out run is abandoned and stops at its next import
+func (e *templateEngine) generateBounded(name string, vars map[string]any, opts GenerateOptions) ([][]echo.Message, map[string]any, error) {
+	timeout := e.config.GenerateTimeout
+	if timeout <= 0 {
+		return e.generate(context.Background(), name, vars, opts)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan generateResult, 1)
+	go func() {
+		conversations, metadata, err := e.generate(ctx, name, vars, opts)
+		done <- generateResult{conversations, metadata, err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.conversations, result.metadata, result.err
+	case <-ctx.Done():
+		return nil, nil, fmt.Errorf("generation of template %q timed out after %v: %w", name, timeout, ctx.Err())
+	}
+}
+
+// generate is the core generation logic
+// Content is rendered as a single conversation unless GenerateOptions.conversations is set
+func (e *templateEngine) generate(runCtx context.Context, name string, vars map[string]any, opts GenerateOptions) ([][]echo.Message, map[string]any, error) {
+	// Locales often come from request headers, so they must not reach into other paths
+	if opts.Locale != "" && !localeRegex.MatchString(opts.Locale) {
+		return nil, nil, fmt.Errorf("invalid locale %q", opts.Locale)
+	}
+
 	// Ensure .md extension (except for stringSource where name is the content)
-	if _, isStringSource := e.source.(*stringSource); !isStringSource && !strings.HasSuffix(name, ".md") {
-		name = name + ".md"
+	if _, isStringSource := e.source.(*stringSource); !isStringSource {
+		name = e.resolveAlias(name)
+		name = e.withExtension(name)
+		name = e.localize(name, opts.Locale)
 	}
 
 	// Load and parse the template
@@ -101,169 +500,956 @@ func (e *templateEngine) generateInternal(name string, vars map[string]any, opts
 		return nil, nil, err
 	}
 
+	// Unbalanced braces would silently produce odd output
+	if opts.StrictMode {
+		if err := checkTemplateBraces(template, name); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	// Check if we're using stringSource and have imports
+	literalImports := false
 	if _, isStringSource := e.source.(*stringSource); isStringSource && len(template.imports) > 0 {
-		return nil, nil, fmt.Errorf("imports are not supported in string templates")
+		if !opts.AllowLiteralImports {
+			return nil, nil, fmt.Errorf("imports are not supported in string templates")
+		}
+		literalImports = true
+	}
+
+	// Resolve shared front-matter and engine defaults
+	metadata, err := e.templateMetadata(template, name, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Merge the selected variant over the base metadata, before it is used as variables
+	if opts.Variant != "" {
+		variants, _ := metadata["variants"].(map[string]map[string]any)
+		variant, ok := variants[opts.Variant]
+		if !ok {
+			return nil, nil, fmt.Errorf("variant %q not found in template %q", opts.Variant, name)
+		}
+		metadata = copyMetadata(metadata)
+		for k, v := range variant {
+			metadata[k] = v
+		}
 	}
 
 	// Convert vars to string map for processing
-	stringVars := convertToStringMap(vars)
+	separator := opts.ListSeparator
+	if separator == "" {
+		separator = defaultListSeparator
+	}
+	stringVars := convertToStringMap(vars, separator, e.config.Coercers)
+	globalVars := convertToStringMap(e.config.GlobalVars, separator, e.config.Coercers)
+
+	// Global vars are visible to dynamic imports unless overridden by call vars
+	importVars := make(map[string]string)
+	for k, v := range globalVars {
+		importVars[k] = v
+	}
+	for k, v := range stringVars {
+		importVars[k] = v
+	}
 
 	// Process imports recursively
-	content, err := e.processImports(template.content, stringVars, opts, name)
+	importCtx := &importContext{vars: importVars, opts: opts, runCtx: runCtx}
+	if opts.Trace {
+		importCtx.trace = &traceTable{}
+	}
+	content := importCtx.trace.mark(template.content, name, template.lineOffset)
+	if literalImports {
+		// Keep {{@...}} as text, escaped so it isn't read as a placeholder
+		content = strings.ReplaceAll(content, "{{@", escapedOpen+"@")
+	} else {
+		content, err = e.expandTemplateImports(importCtx, content, template, name)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Render only the selected block, in the role of the section it is in
+	if opts.block != "" {
+		block, role, ok := extractBlock(content, opts.block)
+		if !ok {
+			return nil, nil, fmt.Errorf("block %q not found in template %q", opts.block, name)
+		}
+		if _, _, marked := parseRoleMarker(strings.SplitN(block, "\n", 2)[0]); role != "" && !marked {
+			block = "@" + role + ":\n" + block
+		}
+		content = block
+	}
+
+	// Block markers only delimit sections for imports
+	content = stripBlockMarkers(content)
+
+	// Merge global vars, metadata, template defaults and provided vars (later wins)
+	mergedVars, rawVars := e.mergeVars(metadata, vars, stringVars, separator)
+	if _, isStringSource := e.source.(*stringSource); !isStringSource {
+		for k, v := range map[string]string{templateNameVar: e.templateName(name), templatePathVar: name} {
+			if _, ok := mergedVars[k]; !ok {
+				mergedVars[k] = v
+			}
+		}
+	}
+
+	// Reject values that don't match their validate.<name> pattern
+	if patterns, ok := metadata["validate"].(map[string]string); ok {
+		if err := validateVars(patterns, template.validators, mergedVars, name); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Front-matter fallbacks apply after inline defaults
+	opts.fallbacks, _ = metadata["fallbacks"].(map[string]string)
+	opts.render, _ = metadata["render_modes"].(map[string]string)
+
+	// Use engine clock unless overridden per call, read once so that
+	// every {{now}} of the generation shows the same time
+	clock := opts.Now
+	if clock == nil {
+		clock = e.config.Clock
+	}
+	if clock == nil {
+		clock = time.Now
+	}
+	now := clock()
+	opts.Now = func() time.Time { return now }
+
+	// Keep or drop {{#if}} blocks
+	content, err = processConditionals(content, mergedVars, opts)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// Merge defaults with provided vars
+	// Each @@@-separated conversation is substituted and parsed on its own
+	var conversations [][]echo.Message
+	var traceSpans []TraceSpan
+	if opts.conversations {
+		for _, chunk := range splitConversations(content) {
+			conversation, _, err := e.renderMessages(chunk, name, mergedVars, rawVars, opts, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			conversations = append(conversations, conversation)
+		}
+	} else {
+		messages, spans, err := e.renderMessages(content, name, mergedVars, rawVars, opts, importCtx.trace)
+		if err != nil {
+			return nil, nil, err
+		}
+		conversations = [][]echo.Message{messages}
+		traceSpans = spans
+	}
+	messages := slices.Concat(conversations...)
+
+	// Guard against templates expanding into too many messages, over all conversations
+	if opts.MaxMessages > 0 && len(messages) > opts.MaxMessages {
+		return nil, nil, fmt.Errorf("template %q produced %d messages, limit is %d", name, len(messages), opts.MaxMessages)
+	}
+
+	// Add estimated prompt size, copying metadata to keep the cached template intact
+	if e.config.TokenCounter != nil || opts.EstimateTokens {
+		metadata = copyMetadata(metadata)
+		metadata["_estimated_tokens"] = e.estimateTokens(messages)
+	}
+
+	// Report imports skipped in non-strict mode
+	if len(importCtx.failures) > 0 {
+		metadata = copyMetadata(metadata)
+		metadata["_import_errors"] = importCtx.failures
+	}
+
+	// Report where each part of the messages came from
+	if opts.Trace {
+		metadata = copyMetadata(metadata)
+		metadata["_trace"] = traceSpans
+	}
+
+	// Remove keys used only by the engine itself
+	if opts.StripInternalMetadata {
+		metadata = copyMetadata(metadata)
+		for _, key := range internalMetadataKeys {
+			delete(metadata, key)
+		}
+	}
+
+	return conversations, metadata, nil
+}
+
+// mergeVars combines the variables of a template, later ones win: global vars,
+// metadata when Config.MetadataAsVars is set, template defaults, conditional defaults
+// matching the selector variable and the call vars (stringVars are vars converted)
+// Original values are returned alongside for filters that need their structure
+func (e *templateEngine) mergeVars(metadata map[string]any, vars map[string]any, stringVars map[string]string, separator string) (map[string]string, map[string]any) {
 	mergedVars := make(map[string]string)
-	if d, ok := template.metadata["defaults"]; ok {
-		if defaultsMap, ok := d.(map[string]string); ok {
-			for k, v := range defaultsMap {
-				mergedVars[k] = v
+	rawVars := make(map[string]any)
+	for k, v := range convertToStringMap(e.config.GlobalVars, separator, e.config.Coercers) {
+		mergedVars[k] = v
+		rawVars[k] = e.config.GlobalVars[k]
+	}
+	if e.config.MetadataAsVars {
+		for k, v := range metadataVars(metadata) {
+			mergedVars[k] = coerceValue(v, separator, e.config.Coercers)
+			rawVars[k] = v
+		}
+	}
+	if defaults, ok := metadata["defaults"].(map[string]string); ok {
+		for k, v := range defaults {
+			mergedVars[k] = v
+			rawVars[k] = v
+		}
+	}
+	// Defaults like greeting[formal] win over the plain default when the selector matches
+	if conditional, ok := metadata["conditional_defaults"].(map[string]map[string]string); ok {
+		selector := defaultSelectorVar
+		if s, ok := metadata["default_selector"].(string); ok && s != "" {
+			selector = s
+		}
+		selected, ok := stringVars[selector]
+		if !ok {
+			selected, ok = mergedVars[selector]
+		}
+		if ok {
+			for k, options := range conditional {
+				if v, ok := options[selected]; ok {
+					mergedVars[k] = v
+					rawVars[k] = v
+				}
 			}
 		}
 	}
 	for k, v := range stringVars {
 		mergedVars[k] = v
+		rawVars[k] = vars[k]
 	}
+	return mergedVars, rawVars
+}
 
+// renderMessages substitutes variables into content and parses it into messages
+func (e *templateEngine) renderMessages(content, name string, vars map[string]string, raw map[string]any, opts GenerateOptions, trace *traceTable) ([]echo.Message, []TraceSpan, error) {
 	// Substitute variables
-	content, err = substituteVariables(content, mergedVars, nil, opts)
+	content, err := substituteVariables(content, vars, raw, opts)
 	if err != nil {
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) && parseErr.Template == "" {
+			parseErr.Template = name
+		}
 		return nil, nil, err
 	}
 
 	// Parse into messages
-	messages := echo.TemplateMessage(content)
+	messages, err := parseMessages(content, name, e.config.AllowedRoles, opts.StrictMode)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// If no messages were parsed (no role markers), create a single user message
-	// This is useful for simple string templates
-	if len(messages) == 0 && content != "" {
+	// If no messages were parsed (no role markers), create a single message
+	// with the default role, this is useful for simple string templates
+	parsed := len(messages) > 0
+	if !parsed && content != "" {
+		role := normalizeRole(strings.TrimSpace(opts.DefaultRole))
+		if role == "" {
+			role = echo.User
+		}
 		messages = []echo.Message{
-			{Role: "user", Content: content},
+			{Role: role, Content: content},
+		}
+	}
+
+	// Sanitized values are literal text again once the roles are known
+	if opts.SanitizeVars {
+		for i := range messages {
+			messages[i].Content = varUnsanitizer.Replace(messages[i].Content)
 		}
 	}
 
-	return messages, template.metadata, nil
+	// Replace trace markers with spans pointing at template lines
+	var traceSpans []TraceSpan
+	if trace != nil {
+		messages, traceSpans = trace.extract(messages, parsed)
+	}
+
+	// Drop messages left blank after substitution
+	if opts.DropEmptyMessages {
+		messages = slices.DeleteFunc(messages, func(msg echo.Message) bool {
+			return strings.TrimSpace(msg.Content) == ""
+		})
+	}
+
+	// Run the configured post-processors in order
+	for i, process := range e.config.MessagePostProcessors {
+		messages, err = process(messages)
+		if err != nil {
+			return nil, nil, fmt.Errorf("message post-processor %d failed for template %q: %w", i, name, err)
+		}
+	}
+	return messages, traceSpans, nil
+}
+
+// templateMetadata returns the template front-matter over the metadata of
+// templates referenced by include_config, directory defaults and Config.DefaultMetadata
+func (e *templateEngine) templateMetadata(template *parsedTemplate, path string, opts GenerateOptions) (map[string]any, error) {
+	metadata, err := e.includeConfig(template, []string{path}, opts)
+	if err != nil {
+		return nil, err
+	}
+	dirMetadata, err := e.directoryMetadata(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.config.DefaultMetadata) == 0 && dirMetadata == nil {
+		return metadata, nil
+	}
+
+	merged := copyMetadata(e.config.DefaultMetadata)
+	mergeMetadata(merged, dirMetadata)
+	mergeMetadata(merged, metadata)
+	return merged, nil
+}
+
+// includeConfig merges the front-matter of the template referenced by
+// include_config beneath the template's own, following nested references
+func (e *templateEngine) includeConfig(template *parsedTemplate, chain []string, opts GenerateOptions) (map[string]any, error) {
+	ref, _ := template.metadata["include_config"].(string)
+	if ref == "" {
+		return template.metadata, nil
+	}
+
+	path := e.resolveAlias(ref)
+	path = e.withExtension(path)
+
+	current := chain[len(chain)-1]
+	if slices.Contains(chain, path) {
+		return nil, fmt.Errorf("circular include_config in template %q: %s", current, strings.Join(append(chain, path), " -> "))
+	}
+
+	base, err := e.loadImport(path, opts)
+	if err != nil {
+		return nil, fmt.Errorf("include_config %q in template %q: %w", ref, current, err)
+	}
+	baseMetadata, err := e.includeConfig(base, append(chain, path), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := copyMetadata(baseMetadata)
+	mergeMetadata(metadata, template.metadata)
+	return metadata, nil
+}
+
+// mergedStringMaps are the map[string]string metadata entries merged key by key
+var mergedStringMaps = []string{"defaults", "fallbacks", "validate", "render_modes", "type_hints"}
+
+// mergeMetadata copies src over dst, the defaults and fallbacks maps are merged key by key
+func mergeMetadata(dst, src map[string]any) {
+	for k, v := range src {
+		if k == "conditional_defaults" {
+			base, _ := dst[k].(map[string]map[string]string)
+			own, _ := v.(map[string]map[string]string)
+			conditional := make(map[string]map[string]string, len(base)+len(own))
+			for name, options := range base {
+				conditional[name] = options
+			}
+			for name, options := range own {
+				merged := make(map[string]string, len(conditional[name])+len(options))
+				for option, value := range conditional[name] {
+					merged[option] = value
+				}
+				for option, value := range options {
+					merged[option] = value
+				}
+				conditional[name] = merged
+			}
+			dst[k] = conditional
+			continue
+		}
+		// Keys like defaults are merged only when both sides hold the engine map
+		base, baseIsMap := dst[k].(map[string]string)
+		own, ownIsMap := v.(map[string]string)
+		if baseIsMap && ownIsMap && slices.Contains(mergedStringMaps, k) {
+			values := make(map[string]string, len(base)+len(own))
+			for name, value := range base {
+				values[name] = value
+			}
+			for name, value := range own {
+				values[name] = value
+			}
+			dst[k] = values
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// Implicit variables describing the generated template, any other source of vars overrides them
+const (
+	templateNameVar = "__template__"
+	templatePathVar = "__path__"
+)
+
+// metadataVars returns the front-matter values usable as variables, skipping
+// engine keys, reported _ keys and nested tables
+func metadataVars(metadata map[string]any) map[string]any {
+	vars := make(map[string]any)
+	for k, v := range metadata {
+		if strings.HasPrefix(k, "_") || slices.Contains(internalMetadataKeys, k) {
+			continue
+		}
+		if _, nested := v.(map[string]any); nested {
+			continue
+		}
+		vars[k] = v
+	}
+	return vars
+}
+
+// internalMetadataKeys are front-matter entries consumed by the engine
+var internalMetadataKeys = []string{"defaults", "fallbacks", "variants", "include_config", "cache", "validate",
+	"conditional_defaults", "default_selector", "render_modes", "type_hints"}
+
+// validateVars checks variable values against their patterns in name order
+// Patterns compiled with the template are reused, inherited ones are compiled here
+// An invalid pattern always fails, missing variables are left to the missing variable handling
+func validateVars(patterns map[string]string, compiled map[string]*regexp.Regexp, vars map[string]string, template string) error {
+	names := make([]string, 0, len(patterns))
+	for name := range patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		re := compiled[name]
+		if re == nil || re.String() != patterns[name] {
+			var err error
+			if re, err = regexp.Compile(patterns[name]); err != nil {
+				return &ParseError{Template: template, Message: fmt.Sprintf("invalid validate.%s pattern: %v", name, err)}
+			}
+		}
+
+		value, ok := vars[name]
+		if ok && !re.MatchString(value) {
+			return &ValidationError{Variable: name, Template: template, Pattern: patterns[name]}
+		}
+	}
+	return nil
+}
+
+// cacheDisabled reports whether the front-matter opts out of caching with cache: false
+// Such templates are never stored, so they are reparsed on every load
+func cacheDisabled(metadata map[string]any) bool {
+	switch value := metadata["cache"].(type) {
+	case bool:
+		return !value
+	case string:
+		return strings.EqualFold(value, "false")
+	}
+	return false
+}
+
+// estimateTokens sums token counts across all message contents
+func (e *templateEngine) estimateTokens(messages []echo.Message) int {
+	counter := e.config.TokenCounter
+	if counter == nil {
+		counter = estimateTokensHeuristic
+	}
+
+	total := 0
+	for _, msg := range messages {
+		total += counter(msg.Content)
+	}
+	return total
+}
+
+// estimateTokensHeuristic approximates tokens as one per four characters
+func estimateTokensHeuristic(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// copyMetadata returns a shallow copy of template metadata
+func copyMetadata(metadata map[string]any) map[string]any {
+	result := make(map[string]any, len(metadata))
+	for k, v := range metadata {
+		result[k] = v
+	}
+	return result
 }
 
 // loadTemplate loads and parses a template file
 func (e *templateEngine) loadTemplate(path string, opts GenerateOptions) (*parsedTemplate, error) {
+	// Entries of the built-in cache checked within Config.CacheCheckInterval are used without a Stat call
+	if cache, ok := e.cacheState().(*templateCache); ok && !opts.DisableCache {
+		if cached, ok := cache.recent(path); ok {
+			return checkParseIssues(cached.parsed(), path, opts)
+		}
+	}
+	return e.reloadTemplate(path, opts)
+}
+
+// reloadTemplate loads a template, comparing a cached entry with the source
+func (e *templateEngine) reloadTemplate(path string, opts GenerateOptions) (*parsedTemplate, error) {
 	// Get file info for cache checking
 	info, err := e.source.Stat(path)
 	if err != nil {
 		return nil, &TemplateNotFoundError{
-			Name: strings.TrimSuffix(path, ".md"),
+			Name: e.templateName(path),
 			Path: path,
 		}
 	}
 
 	// Check cache if enabled (skip in dev mode or if DisableCache is set)
-	if e.cache != nil && !e.devMode && !opts.DisableCache {
-		if cached, ok := e.cache.get(path, info.ModTime); ok {
-			return cached, nil
+	cache := e.cacheState()
+	if cache != nil && !opts.DisableCache {
+		if cached, ok := getCached(cache, path, info.ModTime, info.ETag); ok {
+			if builtin, ok := cache.(*templateCache); ok {
+				builtin.checked(path)
+			}
+			return checkParseIssues(cached, path, opts)
 		}
 	}
 
-	// Read the file
+	data, err := e.readTemplate(path, info)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := e.parseTemplate(path, string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// Cache the parsed template (skip in dev mode or for cache: false templates)
+	if cache != nil && !opts.DisableCache && !cacheDisabled(template.metadata) {
+		putCached(cache, path, template, info.ModTime, info.ETag)
+	}
+
+	return checkParseIssues(template, path, opts)
+}
+
+// readTemplate reads a template file, enforcing Config.MaxTemplateSize
+// The reported size is checked first, reading is limited too in case it is wrong
+func (e *templateEngine) readTemplate(path string, info TemplateInfo) ([]byte, error) {
+	limit := e.config.MaxTemplateSize
+	if limit > 0 && info.Size > limit {
+		return nil, &TemplateSizeError{Path: path, Size: info.Size, Limit: limit}
+	}
+
 	file, err := e.source.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open template file: %w", err)
 	}
 	defer file.Close()
 
+	var reader io.Reader = file
+	if limit > 0 {
+		reader = io.LimitReader(file, limit+1)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template file: %w", err)
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		return nil, &TemplateSizeError{Path: path, Size: int64(len(data)), Limit: limit}
+	}
+	return data, nil
+}
+
+// parseTemplate preprocesses and parses raw template text
+func (e *templateEngine) parseTemplate(path, text string) (*parsedTemplate, error) {
+	// Let the application transform the raw text before parsing
+	if e.config.PreProcess != nil {
+		var err error
+		text, err = e.config.PreProcess(path, text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to preprocess template: %w", err)
+		}
+	}
+
 	// Parse front-matter and content
-	metadata, content, err := parseFrontMatter(file)
+	metadata, content, issues, err := scanFrontMatter(strings.NewReader(text), e.config.FrontMatterDelimiter)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse template: %w", err)
 	}
 
-	// Extract imports
-	imports := extractImports(content)
+	// Remove authoring notes before looking for imports
+	if e.config.StripHTMLComments {
+		content = stripHTMLComments(content)
+	}
+
+	return &parsedTemplate{
+		metadata:   metadata,
+		content:    content,
+		imports:    extractImports(content),
+		issues:     issues,
+		lineOffset: frontMatterLines(text, e.config.FrontMatterDelimiter),
+		validators: compileValidators(metadata),
+	}, nil
+}
+
+// loadImport loads an imported template, preferring virtual partials over the source
+func (e *templateEngine) loadImport(path string, opts GenerateOptions) (*parsedTemplate, error) {
+	e.partialsMu.RLock()
+	text, ok := e.partials[path]
+	e.partialsMu.RUnlock()
+	if !ok {
+		return e.loadTemplate(path, opts)
+	}
+
+	template, err := e.parseTemplate(path, text)
+	if err != nil {
+		return nil, err
+	}
+	return checkParseIssues(template, path, opts)
+}
+
+// hasPartial reports whether a virtual template is registered under path
+func (e *templateEngine) hasPartial(path string) bool {
+	e.partialsMu.RLock()
+	defer e.partialsMu.RUnlock()
+	_, ok := e.partials[path]
+	return ok
+}
+
+// SetPartial registers a virtual template that takes precedence over the source during imports
+func (e *templateEngine) SetPartial(path, content string) {
+	path = e.withExtension(path)
+
+	e.partialsMu.Lock()
+	defer e.partialsMu.Unlock()
+	if e.partials == nil {
+		e.partials = make(map[string]string)
+	}
+	e.partials[path] = content
+}
+
+// RemovePartial unregisters a virtual template added by SetPartial
+func (e *templateEngine) RemovePartial(path string) {
+	path = e.withExtension(path)
+
+	e.partialsMu.Lock()
+	defer e.partialsMu.Unlock()
+	delete(e.partials, path)
+}
+
+// localeRegex matches language tags like fr, fr-CA or zh_Hant_TW
+var localeRegex = regexp.MustCompile(`^[A-Za-z]{2,3}([-_][A-Za-z0-9]{1,8})*$`)
+
+// localize returns the most specific existing localized version of a template path,
+// e.g. greeting.fr-CA.md, then greeting.fr.md, then the path itself
+// Locales that are not language tags are ignored
+func (e *templateEngine) localize(templatePath, locale string) string {
+	if !localeRegex.MatchString(locale) {
+		return templatePath
+	}
+
+	ext := path.Ext(templatePath)
+	base := strings.TrimSuffix(templatePath, ext)
+	locales := []string{locale}
+	if language, _, ok := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-"); ok && language != "" {
+		locales = append(locales, language)
+	}
+
+	for _, candidate := range locales {
+		localized := base + "." + candidate + ext
+		if e.hasPartial(localized) {
+			return localized
+		}
+		if _, err := e.source.Stat(localized); err == nil {
+			return localized
+		}
+	}
+	return templatePath
+}
+
+// withExtension appends the .md extension to a template path that lacks it,
+// unless Config.DisableAutoExtension is set
+func (e *templateEngine) withExtension(path string) string {
+	if !e.config.DisableAutoExtension && !strings.HasSuffix(path, ".md") {
+		path = path + ".md"
+	}
+	return path
+}
+
+// templateName returns the template name of a path, without the .md extension
+// unless Config.DisableAutoExtension is set
+func (e *templateEngine) templateName(path string) string {
+	if e.config.DisableAutoExtension {
+		return path
+	}
+	return strings.TrimSuffix(path, ".md")
+}
 
-	template := &parsedTemplate{
-		metadata: metadata,
-		content:  content,
-		imports:  imports,
+// checkTemplateBraces reports unbalanced placeholder braces with the line in the template file
+func checkTemplateBraces(template *parsedTemplate, path string) error {
+	issue := checkBraces(template.content)
+	if issue == nil {
+		return nil
 	}
+	issue.Template = path
+	issue.Line += template.lineOffset
+	return issue
+}
+
+// checkParseIssues rejects templates with malformed front-matter in strict mode
+func checkParseIssues(template *parsedTemplate, path string, opts GenerateOptions) (*parsedTemplate, error) {
+	if opts.StrictMode && len(template.issues) > 0 {
+		issue := *template.issues[0]
+		issue.Template = path
+		return nil, &issue
+	}
+	return template, nil
+}
+
+// importContext carries the state of a single import expansion
+type importContext struct {
+	vars map[string]string
+	opts GenerateOptions
+
+	// runCtx stops import loading once a generation timed out (see Config.GenerateTimeout)
+	runCtx context.Context
+
+	// failures collects imports skipped in non-strict mode
+	failures []error
+
+	// trace marks source lines when GenerateOptions.Trace is set
+	trace *traceTable
+
+	// deps lists the imported template paths, volatile marks expansions that
+	// depend on variables or virtual partials and can't be flattened
+	deps     []string
+	volatile bool
+
+	// skipped lists the paths of {{@primary|fallback}} alternatives that failed to load
+	skipped []string
+}
+
+// addDep records an imported template path once
+func (ctx *importContext) addDep(path string) {
+	if !slices.Contains(ctx.deps, path) {
+		ctx.deps = append(ctx.deps, path)
+	}
+}
+
+// skip records a failed import alternative once
+func (ctx *importContext) skip(path string) {
+	if !slices.Contains(ctx.skipped, path) {
+		ctx.skipped = append(ctx.skipped, path)
+	}
+}
+
+// processImports recursively processes import placeholders
+func (e *templateEngine) processImports(content string, vars map[string]string, opts GenerateOptions, currentTemplate string) (string, error) {
+	ctx := &importContext{vars: vars, opts: opts}
+	return e.expandImports(ctx, content, currentTemplate)
+}
+
+// expandImports processes import placeholders using the given context
+func (e *templateEngine) expandImports(ctx *importContext, content string, currentTemplate string) (string, error) {
+	// Keep track of the import chain to detect cycles
+	stack := []string{currentTemplate}
+
+	return e.processImportsRecursive(ctx, content, currentTemplate, stack)
+}
+
+// isGlobImport reports whether an import expression is a glob such as common/*
+// Dynamic imports are never globs, their variables may contain any text
+func isGlobImport(importExpr string) bool {
+	return strings.ContainsAny(importExpr, "*?[") && !strings.Contains(importExpr, "{{")
+}
+
+// expandGlobImports rewrites each {{@pattern}} glob import as imports of the matching
+// templates in sorted order, skipping templates of the current import chain
+// The source is listed on every call, the listing isn't cached as sources have
+// no change notification for new files besides Watch in dev mode
+func (e *templateEngine) expandGlobImports(ctx *importContext, content, currentTemplate string, stack []string) (string, error) {
+	for _, importExpr := range extractImports(content) {
+		if !isGlobImport(importExpr) {
+			continue
+		}
+
+		// The directory listing may change without any imported template changing
+		ctx.volatile = true
+
+		pattern := strings.TrimSpace(importExpr)
+		if e.config.RelativeImports && (strings.HasPrefix(pattern, "./") || strings.HasPrefix(pattern, "../")) {
+			pattern = path.Join(path.Dir(currentTemplate), pattern)
+		}
+		matches, err := e.findTemplates(pattern, true)
+		if err == nil && len(matches) == 0 {
+			err = fmt.Errorf("no templates match %q", pattern)
+		}
+		if err != nil {
+			importErr := &ImportError{ImportPath: importExpr, Template: currentTemplate, Cause: err}
+			if ctx.opts.StrictMode {
+				return "", importErr
+			}
+			// In non-strict mode, keep the placeholder
+			ctx.failures = append(ctx.failures, importErr)
+			continue
+		}
+
+		var expanded []string
+		for _, match := range matches {
+			if !slices.Contains(stack, e.withExtension(match)) {
+				expanded = append(expanded, "{{@"+match+"}}")
+			}
+		}
+		content = strings.ReplaceAll(content, "{{@"+importExpr+"}}", strings.Join(expanded, "\n"))
+	}
+	return content, nil
+}
+
+// processImportsRecursive handles the actual recursive import processing
+// stack holds the chain of templates being imported, starting from the root
+func (e *templateEngine) processImportsRecursive(ctx *importContext, content string, currentTemplate string, stack []string) (string, error) {
+	vars, opts := ctx.vars, ctx.opts
+
+	// Hide escaped braces so they are not treated as imports
+	content = escapeBraces(content)
+
+	// Replace {{@dir/*}} with an import of each matching template
+	content, err := e.expandGlobImports(ctx, content, currentTemplate, stack)
+	if err != nil {
+		return "", err
+	}
+
+	// Process imports using the extractImports function which handles nested placeholders
+	// Glob imports left after expansion failed and keep their placeholder
+	imports := slices.DeleteFunc(extractImports(content), isGlobImport)
+	prefetched := e.prefetchImports(imports, vars, opts, currentTemplate)
+
+	for _, importExpr := range imports {
+		fullMatch := "{{@" + importExpr + "}}"
 
-	// Cache the parsed template (skip in dev mode)
-	if e.cache != nil && !e.devMode && !opts.DisableCache {
-		e.cache.put(path, template, info.ModTime)
-	}
+		if ctx.runCtx != nil {
+			if err := ctx.runCtx.Err(); err != nil {
+				return "", err
+			}
+		}
 
-	return template, nil
-}
+		// Import paths built from variables may be controlled by untrusted input
+		if opts.DisableDynamicImports && strings.Contains(importExpr, "{{") {
+			importErr := &ImportError{
+				ImportPath: importExpr,
+				Template:   currentTemplate,
+				Cause:      fmt.Errorf("dynamic imports are disabled"),
+			}
+			if opts.StrictMode {
+				return "", importErr
+			}
+			// In non-strict mode, keep the placeholder
+			ctx.failures = append(ctx.failures, importErr)
+			continue
+		}
 
-// processImports recursively processes import placeholders
-func (e *templateEngine) processImports(content string, vars map[string]string, opts GenerateOptions, currentTemplate string) (string, error) {
-	// Keep track of processed imports to avoid infinite recursion
-	processed := make(map[string]bool)
+		// Dynamic imports differ between generations
+		if strings.Contains(importExpr, "{{") {
+			ctx.volatile = true
+		}
 
-	return e.processImportsRecursive(content, vars, opts, currentTemplate, processed)
-}
+		// Try each alternative of {{@primary|fallback}} in order
+		var importPath string
+		var importKey string
+		var importedContent string
+		var importedMetadata map[string]any
+		var err error
+		circular := false
+		for _, candidate := range splitImportAlternatives(importExpr) {
+			// Split off the optional #section fragment
+			candidate, fragment := splitImportFragment(candidate)
+			loaded, isPrefetched := prefetched[candidate]
+			if isPrefetched {
+				importPath = loaded.path
+			} else {
+				importPath = e.localize(e.resolveImportPath(candidate, vars, opts, currentTemplate), opts.Locale)
+			}
 
-// processImportsRecursive handles the actual recursive import processing
-func (e *templateEngine) processImportsRecursive(content string, vars map[string]string, opts GenerateOptions, currentTemplate string, processed map[string]bool) (string, error) {
-	// Process imports using the extractImports function which handles nested placeholders
-	imports := extractImports(content)
+			// Sections of the same template are tracked separately
+			importKey = importPath
+			if fragment != "" {
+				importKey += "#" + fragment
+			}
 
-	for _, importPath := range imports {
-		fullMatch := "{{@" + importPath + "}}"
+			// Check for circular imports, including a template importing itself
+			if importKey == currentTemplate || slices.Contains(stack, importKey) {
+				circular = true
+				break
+			}
 
-		// Handle dynamic imports (e.g., {{@{{template_type}}/header}})
-		importPath = placeholderRegex.ReplaceAllStringFunc(importPath, func(innerMatch string) string {
-			varName := strings.TrimSpace(innerMatch[2 : len(innerMatch)-2])
-			if value, ok := vars[varName]; ok {
-				return value
+			// Dynamic imports may only reach allowlisted templates
+			if err = e.checkDynamicImport(candidate, vars, opts, currentTemplate); err != nil {
+				continue
 			}
-			return innerMatch
-		})
 
-		// Ensure .md extension
-		if !strings.HasSuffix(importPath, ".md") {
-			importPath = importPath + ".md"
-		}
+			// Load the imported template
+			var importedTemplate *parsedTemplate
+			if isPrefetched {
+				importedTemplate, err = loaded.template, loaded.err
+			} else {
+				importedTemplate, err = e.loadImport(importPath, opts)
+			}
+			if err != nil {
+				// A skipped alternative decides the result once it appears or is fixed
+				ctx.skip(importPath)
+				continue
+			}
 
-		// Allow source to customize import resolution
-		if customPath := e.source.ResolveImport(importPath, currentTemplate); customPath != "" {
-			importPath = customPath
+			importedMetadata = importedTemplate.metadata
+			importedContent = ctx.trace.mark(importedTemplate.content, importPath, importedTemplate.lineOffset)
+			if fragment != "" {
+				section, ok := extractSection(importedContent, fragment)
+				if !ok {
+					err = fmt.Errorf("section %q not found", fragment)
+					ctx.skip(importPath)
+					continue
+				}
+				importedContent = section
+			}
+			break
 		}
 
-		// Check for circular imports
-		if processed[importPath] {
+		if circular {
+			cause := fmt.Errorf("circular import detected: %s", strings.Join(append(stack, importKey), " -> "))
+			if importKey == currentTemplate {
+				cause = fmt.Errorf("template imports itself")
+			}
+			importErr := &ImportError{
+				ImportPath: importPath,
+				Template:   currentTemplate,
+				Cause:      cause,
+			}
 			if opts.StrictMode {
-				return "", &ImportError{
-					ImportPath: importPath,
-					Template:   currentTemplate,
-					Cause:      fmt.Errorf("circular import detected"),
-				}
+				return "", importErr
 			}
 			// In non-strict mode, just skip the import
+			ctx.failures = append(ctx.failures, importErr)
 			content = strings.ReplaceAll(content, fullMatch, "")
 			continue
 		}
 
-		// Mark as processed
-		processed[importPath] = true
-
-		// Load the imported template
-		importedTemplate, err := e.loadTemplate(importPath, opts)
 		if err != nil {
+			importErr := &ImportError{
+				ImportPath: importPath,
+				Template:   currentTemplate,
+				Cause:      err,
+			}
 			if opts.StrictMode {
-				return "", &ImportError{
-					ImportPath: importPath,
-					Template:   currentTemplate,
-					Cause:      err,
-				}
+				return "", importErr
 			}
 			// In non-strict mode, keep the placeholder
+			ctx.failures = append(ctx.failures, importErr)
 			continue
 		}
 
+		// Partials and cache: false imports may change without a new file version
+		if e.hasPartial(importPath) || cacheDisabled(importedMetadata) {
+			ctx.volatile = true
+		} else {
+			ctx.addDep(importPath)
+		}
+
 		// Process imports in the imported content recursively
-		importedContent, err := e.processImportsRecursive(importedTemplate.content, vars, opts, importPath, processed)
+		importedContent, err = e.processImportsRecursive(ctx, importedContent, importPath, append(stack, importKey))
 		if err != nil {
 			return "", err
 		}
@@ -275,37 +1461,164 @@ func (e *templateEngine) processImportsRecursive(content string, vars map[string
 	return content, nil
 }
 
+// resolveAlias returns the template path for an alias, or name itself
+func (e *templateEngine) resolveAlias(name string) string {
+	if target, ok := e.config.Aliases[e.templateName(name)]; ok {
+		return target
+	}
+	return name
+}
+
+// resolveImportPath turns an import expression into a template path
+// The source is asked on every call, so imports it resolves follow changes to its files
+func (e *templateEngine) resolveImportPath(importPath string, vars map[string]string, opts GenerateOptions, currentTemplate string) string {
+	importPath = e.expandImportPath(importPath, vars, opts, currentTemplate)
+
+	// Allow source to customize import resolution
+	if customPath := e.source.ResolveImport(importPath, currentTemplate); customPath != "" {
+		importPath = customPath
+	}
+
+	return importPath
+}
+
+// expandImportPath expands an import expression into a template path before source resolution
+func (e *templateEngine) expandImportPath(importPath string, vars map[string]string, opts GenerateOptions, currentTemplate string) string {
+	// Dynamic imports are memoized by the values of the variables they use
+	if !strings.Contains(importPath, "{{") {
+		return e.expandImportExpr(importPath, vars, opts, currentTemplate)
+	}
+
+	key := importPathKey(importPath, vars, opts, currentTemplate)
+	e.importPathsMu.RLock()
+	expanded, ok := e.importPaths[key]
+	e.importPathsMu.RUnlock()
+	if ok {
+		return expanded
+	}
+
+	expanded = e.expandImportExpr(importPath, vars, opts, currentTemplate)
+
+	e.importPathsMu.Lock()
+	if e.importPaths == nil || len(e.importPaths) >= maxImportPaths {
+		e.importPaths = make(map[string]string)
+	}
+	e.importPaths[key] = expanded
+	e.importPathsMu.Unlock()
+
+	return expanded
+}
+
+// maxImportPaths bounds the memoized dynamic import paths, the memo is reset when full
+const maxImportPaths = 1024
+
+// importPathKey identifies a dynamic import by template, expression and variable values
+func importPathKey(importPath string, vars map[string]string, opts GenerateOptions, currentTemplate string) string {
+	var b strings.Builder
+	b.WriteString(currentTemplate)
+	b.WriteByte(0)
+	b.WriteString(importPath)
+
+	rest := importPath
+	for {
+		start := strings.Index(rest, "{{")
+		if start == -1 {
+			break
+		}
+		end := strings.Index(rest[start:], "}}")
+		if end == -1 {
+			break
+		}
+		name := strings.TrimSpace(rest[start+2 : start+end])
+		rest = rest[start+end+2:]
+
+		// Missing variables stay unresolved, unlike empty ones
+		b.WriteByte(0)
+		if value, ok := lookupVar(name, vars, opts); ok {
+			b.WriteString(value)
+		} else {
+			b.WriteByte(1)
+		}
+	}
+	return b.String()
+}
+
+// checkDynamicImport rejects a dynamic import that matches no Config.DynamicImportAllowlist
+// pattern, by path or template name
+// Patterns see the expanded expression before the source resolves it, since
+// imports found in import directories resolve to absolute paths
+func (e *templateEngine) checkDynamicImport(candidate string, vars map[string]string, opts GenerateOptions, currentTemplate string) error {
+	allowlist := e.config.DynamicImportAllowlist
+	if len(allowlist) == 0 || !strings.Contains(candidate, "{{") {
+		return nil
+	}
+	importPath := e.expandImportPath(candidate, vars, opts, currentTemplate)
+
+	// Match the cleaned path, so personas/../secrets can't pass as personas/*/*
+	importPath = path.Clean(importPath)
+	name := e.templateName(importPath)
+	for _, pattern := range allowlist {
+		if ok, _ := path.Match(pattern, importPath); ok {
+			return nil
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("dynamic import resolved to %q, which is not in the allowlist", importPath)
+}
+
+// expandImportExpr substitutes variables and aliases in an import expression
+func (e *templateEngine) expandImportExpr(importPath string, vars map[string]string, opts GenerateOptions, currentTemplate string) string {
+	// Handle dynamic imports (e.g., {{@{{template_type}}/header}})
+	importPath = placeholderRegex.ReplaceAllStringFunc(importPath, func(innerMatch string) string {
+		varName := strings.TrimSpace(innerMatch[2 : len(innerMatch)-2])
+		if value, ok := lookupVar(varName, vars, opts); ok {
+			return value
+		}
+		return innerMatch
+	})
+	importPath = e.resolveAlias(importPath)
+
+	// Ensure .md extension
+	importPath = e.withExtension(importPath)
+
+	// Resolve ./ and ../ imports against the directory of the current template
+	if e.config.RelativeImports && (strings.HasPrefix(importPath, "./") || strings.HasPrefix(importPath, "../")) {
+		importPath = path.Join(path.Dir(currentTemplate), importPath)
+	}
+
+	return importPath
+}
+
+// defaultListSeparator joins []string values unless GenerateOptions.ListSeparator is set
+const defaultListSeparator = ","
+
 // toString converts any value to string representation
 func toString(v any) string {
-	switch val := v.(type) {
-	case string:
-		return val
-	case int:
-		return strconv.Itoa(val)
-	case float64:
-		return strconv.FormatFloat(val, 'f', -1, 64)
-	case []string:
-		return strings.Join(val, ",")
-	default:
-		return ""
-	}
+	return toStringWithSeparator(v, defaultListSeparator)
+}
+
+// toStringWithSeparator converts any value to string, joining lists with separator
+func toStringWithSeparator(v any, separator string) string {
+	return coerceValue(v, separator, nil)
 }
 
 // convertToStringMap converts map[string]any to map[string]string
-func convertToStringMap(vars map[string]any) map[string]string {
+func convertToStringMap(vars map[string]any, separator string, coercers map[reflect.Kind]Coercer) map[string]string {
 	result := make(map[string]string)
 	for k, v := range vars {
-		result[k] = toString(v)
+		result[k] = coerceValue(v, separator, coercers)
 	}
 	return result
 }
 
 // ValidateTemplate checks if a template is valid without generating messages
 func (e *templateEngine) ValidateTemplate(name string) error {
+	name = e.resolveAlias(name)
+
 	// Ensure .md extension
-	if !strings.HasSuffix(name, ".md") {
-		name = name + ".md"
-	}
+	name = e.withExtension(name)
 
 	// Try to load and parse the template
 	_, err := e.loadTemplate(name, e.config.DefaultOptions)
@@ -315,15 +1628,165 @@ func (e *templateEngine) ValidateTemplate(name string) error {
 
 	// Check for circular imports by processing imports with empty vars
 	template, _ := e.loadTemplate(name, e.config.DefaultOptions)
+	if err := checkTemplateBraces(template, name); err != nil {
+		return err
+	}
 	_, err = e.processImports(template.content, make(map[string]string), e.config.DefaultOptions, name)
-	return err
+	if err != nil {
+		return err
+	}
+
+	// Check shared front-matter references
+	metadata, err := e.templateMetadata(template, name, e.config.DefaultOptions)
+	if err != nil {
+		return err
+	}
+
+	// Check metadata against the schema and the known keys
+	var errs []error
+	if e.config.MetadataSchema != nil {
+		errs = append(errs, e.config.MetadataSchema.validate(name, metadata))
+	}
+	if e.config.StrictMetadata {
+		errs = append(errs, checkUnknownKeys(name, template.metadata, e.config.KnownMetadataKeys))
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateAll validates every template and returns all errors joined
+func (e *templateEngine) ValidateAll() error {
+	templates, err := e.ListTemplates()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range templates {
+		if err := e.ValidateTemplate(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RawTemplate returns the template file text as stored in the source
+func (e *templateEngine) RawTemplate(name string) (string, error) {
+	name = e.resolveAlias(name)
+
+	// Ensure .md extension
+	name = e.withExtension(name)
+
+	info, err := e.source.Stat(name)
+	if err != nil {
+		return "", &TemplateNotFoundError{
+			Name: e.templateName(name),
+			Path: name,
+		}
+	}
+
+	data, err := e.readTemplate(name, info)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// LintTemplate reports potential problems in a template, such as
+// placeholders in system sections, lines are relative to the template file
+func (e *templateEngine) LintTemplate(name string) ([]LintIssue, error) {
+	name = e.resolveAlias(name)
+
+	// Ensure .md extension
+	name = e.withExtension(name)
+
+	template, err := e.loadTemplate(name, e.config.DefaultOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	issues := lintSystemPlaceholders(template.content)
+	for i := range issues {
+		issues[i].Template = name
+		issues[i].Line += template.lineOffset
+	}
+	return issues, nil
+}
+
+// GetTemplateVersion returns the version declared in the template front-matter
+func (e *templateEngine) GetTemplateVersion(name string) (string, error) {
+	name = e.resolveAlias(name)
+
+	// Ensure .md extension
+	name = e.withExtension(name)
+
+	template, err := e.loadTemplate(name, e.config.DefaultOptions)
+	if err != nil {
+		return "", err
+	}
+
+	// Include config and default metadata can provide the version too
+	metadata, err := e.templateMetadata(template, name, e.config.DefaultOptions)
+	if err != nil {
+		return "", err
+	}
+
+	version, ok := metadata["version"]
+	if !ok {
+		return "", nil
+	}
+	return fmt.Sprint(version), nil
+}
+
+// Fingerprint returns a hash over the text of a template and its transitive imports
+// Dynamic imports that can't be resolved without variables and virtual partials are not included
+func (e *templateEngine) Fingerprint(name string) (string, error) {
+	name = e.resolveAlias(name)
+
+	// Ensure .md extension
+	name = e.withExtension(name)
+
+	template, err := e.loadTemplate(name, e.config.DefaultOptions)
+	if err != nil {
+		return "", err
+	}
+
+	// Collect the imported files, unresolvable imports are skipped
+	opts := e.config.DefaultOptions
+	opts.StrictMode = false
+	ctx := &importContext{vars: make(map[string]string), opts: opts}
+	if _, err := e.expandImports(ctx, template.content, name); err != nil {
+		return "", err
+	}
+
+	paths := append([]string{name}, ctx.deps...)
+	sort.Strings(paths[1:])
+
+	hash := sha256.New()
+	for _, path := range paths {
+		info, err := e.source.Stat(path)
+		if err != nil {
+			return "", &TemplateNotFoundError{Name: e.templateName(path), Path: path}
+		}
+		data, err := e.readTemplate(path, info)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", path, len(data))
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 // GetTemplateVariables returns all variable names used in a template
+// Results are memoized until the template or one of its imports changes
 func (e *templateEngine) GetTemplateVariables(name string) ([]string, error) {
+	name = e.resolveAlias(name)
+
 	// Ensure .md extension
-	if !strings.HasSuffix(name, ".md") {
-		name = name + ".md"
+	name = e.withExtension(name)
+
+	if variables, ok := e.memoizedVariables(name); ok {
+		return variables, nil
 	}
 
 	// Load the template
@@ -333,14 +1796,24 @@ func (e *templateEngine) GetTemplateVariables(name string) ([]string, error) {
 	}
 
 	// Process imports to get full content
-	content, err := e.processImports(template.content, make(map[string]string), e.config.DefaultOptions, name)
+	ctx := &importContext{vars: make(map[string]string), opts: e.config.DefaultOptions}
+	content, err := e.expandImports(ctx, template.content, name)
 	if err != nil {
 		return nil, err
 	}
 
+	// Block markers are not variables
+	content = stripBlockMarkers(content)
+
 	// Extract all variables
 	variableMap := make(map[string]bool)
 
+	// Variables tested by {{#if}} tags
+	for _, name := range conditionVariables(content) {
+		variableMap[name] = true
+	}
+	content = conditionTagRegex.ReplaceAllString(content, "")
+
 	// First, remove triple brace placeholders to avoid double matching
 	contentWithoutRaw := rawPlaceholderRegex.ReplaceAllString(content, "")
 
@@ -349,6 +1822,10 @@ func (e *templateEngine) GetTemplateVariables(name string) ([]string, error) {
 	for _, match := range matches {
 		if len(match) > 1 && !strings.HasPrefix(match[0], "{{@") {
 			inner := strings.TrimSpace(match[1])
+			// Time placeholders and image references are not variables
+			if strings.HasPrefix(inner, nowPrefix) || strings.HasPrefix(inner, imagePrefix) {
+				continue
+			}
 			// Handle default value syntax
 			parts := strings.SplitN(inner, "|", 2)
 			varName := strings.TrimSpace(parts[0])
@@ -365,6 +1842,10 @@ func (e *templateEngine) GetTemplateVariables(name string) ([]string, error) {
 		}
 	}
 
+	// Implicit variables are always available
+	delete(variableMap, templateNameVar)
+	delete(variableMap, templatePathVar)
+
 	// Convert map to sorted slice
 	var variables []string
 	for v := range variableMap {
@@ -372,15 +1853,76 @@ func (e *templateEngine) GetTemplateVariables(name string) ([]string, error) {
 	}
 	sort.Strings(variables)
 
+	// Expansions that depend on variables, partials or failed imports can change unnoticed
+	if !ctx.volatile && len(ctx.failures) == 0 {
+		e.memoizeVariables(name, append(slices.Clone(ctx.deps), ctx.skipped...), variables)
+	}
 	return variables, nil
 }
 
+// templateVariables is a memoized variable list with the template versions it was computed from
+type templateVariables struct {
+	versions  []TemplateVersion
+	variables []string
+}
+
+// maxMemoizedVariables bounds the memoized variable lists, the memo is reset when full
+const maxMemoizedVariables = 1024
+
+// memoizedVariables returns the memoized variables of a template if it and its imports are unchanged
+func (e *templateEngine) memoizedVariables(path string) ([]string, bool) {
+	e.variablesMu.Lock()
+	memo, ok := e.variables[path]
+	e.variablesMu.Unlock()
+	if !ok || !e.versionsCurrent(memo.versions) {
+		return nil, false
+	}
+	return slices.Clone(memo.variables), true
+}
+
+// memoizeVariables records the variables of a template with the versions of it and its imports
+func (e *templateEngine) memoizeVariables(path string, deps []string, variables []string) {
+	versions := e.templateVersions(append([]string{path}, deps...))
+	if versions[0].Absent {
+		return
+	}
+
+	e.variablesMu.Lock()
+	defer e.variablesMu.Unlock()
+	if e.variables == nil || len(e.variables) >= maxMemoizedVariables {
+		e.variables = make(map[string]templateVariables)
+	}
+	e.variables[path] = templateVariables{versions: versions, variables: slices.Clone(variables)}
+}
+
+// AllTemplateVariables returns variables of every template keyed by template name
+// The variables of each template are memoized by GetTemplateVariables, and parsed
+// templates are shared through the engine cache, so imports used by several
+// templates are only parsed once in production mode
+func (e *templateEngine) AllTemplateVariables() (map[string][]string, error) {
+	templates, err := e.ListTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]string, len(templates))
+	for _, name := range templates {
+		variables, err := e.GetTemplateVariables(name)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = variables
+	}
+
+	return result, nil
+}
+
 // TemplateExists checks if a template file exists
 func (e *templateEngine) TemplateExists(name string) bool {
+	name = e.resolveAlias(name)
+
 	// Ensure .md extension
-	if !strings.HasSuffix(name, ".md") {
-		name = name + ".md"
-	}
+	name = e.withExtension(name)
 
 	// Check if file exists
 	info, err := e.source.Stat(name)
@@ -388,16 +1930,172 @@ func (e *templateEngine) TemplateExists(name string) bool {
 }
 
 // ListTemplates returns all available template paths relative to source root
+// Templates under Config.PartialsDir are only used through imports and not listed
 func (e *templateEngine) ListTemplates() ([]string, error) {
-	templates, err := e.source.List()
+	templates, err := e.listSourceTemplates()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(templates, e.inPartialsDir), nil
+}
+
+// sourcePaths returns the template paths of the source, files without an extension
+// are included when Config.DisableAutoExtension is set and the source can list them
+func (e *templateEngine) sourcePaths() ([]string, error) {
+	paths, err := e.source.List()
+	if err != nil {
+		return nil, err
+	}
+
+	lister, ok := e.source.(ExtensionlessLister)
+	if !e.config.DisableAutoExtension || !ok {
+		return paths, nil
+	}
+	extensionless, err := lister.ListExtensionless()
+	if err != nil {
+		return nil, err
+	}
+	paths = append(paths, extensionless...)
+	sort.Strings(paths)
+	return slices.Compact(paths), nil
+}
+
+// listSourceTemplates returns the template names of the source, partials included
+func (e *templateEngine) listSourceTemplates() ([]string, error) {
+	templates, err := e.sourcePaths()
 	if err != nil {
 		return nil, err
 	}
 
+	// Directory defaults files are not templates
+	templates = slices.DeleteFunc(templates, isDirDefaultsFile)
+
 	// Remove .md extension for consistency with other methods
 	for i, template := range templates {
-		templates[i] = strings.TrimSuffix(template, ".md")
+		templates[i] = e.templateName(template)
 	}
 
 	return templates, nil
 }
+
+// inPartialsDir reports whether a template name is under Config.PartialsDir
+func (e *templateEngine) inPartialsDir(name string) bool {
+	if e.config.PartialsDir == "" {
+		return false
+	}
+	dir := strings.Trim(path.Clean(e.config.PartialsDir), "/")
+	return strings.HasPrefix(name, dir+"/")
+}
+
+// FindTemplates returns template paths matching a glob pattern
+func (e *templateEngine) FindTemplates(pattern string) ([]string, error) {
+	return e.findTemplates(pattern, false)
+}
+
+// findTemplates matches a glob pattern against the template names,
+// glob imports also match partials
+func (e *templateEngine) findTemplates(pattern string, partials bool) ([]string, error) {
+	templates, err := e.listSourceTemplates()
+	if err != nil {
+		return nil, err
+	}
+	if !partials {
+		templates = slices.DeleteFunc(templates, e.inPartialsDir)
+	}
+
+	pattern = e.templateName(pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	var matches []string
+	for _, template := range templates {
+		if matchGlob(strings.Split(pattern, "/"), strings.Split(template, "/")) {
+			matches = append(matches, template)
+		}
+	}
+	return matches, nil
+}
+
+// ListTemplatesByMetadata returns templates whose metadata key matches value
+// For list values such as tags, the list must contain value
+func (e *templateEngine) ListTemplatesByMetadata(key string, value any) ([]string, error) {
+	templates, err := e.ListTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := e.config.DefaultOptions
+	var matches []string
+	for _, name := range templates {
+		template, err := e.loadTemplate(e.withExtension(name), opts)
+		if err != nil {
+			return nil, err
+		}
+		metadata, err := e.templateMetadata(template, e.withExtension(name), opts)
+		if err != nil {
+			return nil, err
+		}
+		if actual, ok := metadata[key]; ok && metadataMatches(actual, value) {
+			matches = append(matches, name)
+		}
+	}
+	return matches, nil
+}
+
+// metadataMatches compares a metadata value, numbers are compared by value,
+// lists match if any element matches and other values are compared deeply
+func metadataMatches(actual, expected any) bool {
+	if list, ok := actual.([]string); ok && !isList(expected) {
+		return slices.ContainsFunc(list, func(item string) bool {
+			return metadataMatches(item, expected)
+		})
+	}
+
+	if a, ok := toFloat(actual); ok {
+		b, ok := toFloat(expected)
+		return ok && a == b
+	}
+	// Maps and lists, e.g. TOML tables, can't be compared with ==
+	return reflect.DeepEqual(actual, expected)
+}
+
+// isList reports whether a value is a slice
+func isList(v any) bool {
+	return v != nil && reflect.TypeOf(v).Kind() == reflect.Slice
+}
+
+// toFloat converts numeric metadata values to float64
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// matchGlob matches path segments, where a ** segment matches zero or more segments
+func matchGlob(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchGlob(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchGlob(pattern[1:], segments[1:])
+}
@@ -135,11 +135,11 @@ func TestGenerateWithUnsupportedTypes(t *testing.T) {
 		expected string
 	}{
 		{
-			name: "unsupported type - bool",
+			name: "bool",
 			vars: map[string]any{
 				"value": true,
 			},
-			expected: "Value: ", // Unsupported types convert to empty string
+			expected: "Value: true",
 		},
 		{
 			name: "unsupported type - map",
@@ -189,3 +189,28 @@ func containsHelper(s, substr string) bool {
 	}
 	return false
 }
+
+func TestGenerateListSeparator(t *testing.T) {
+	// Quote the separator to keep surrounding whitespace
+	template := "Tags: {{tags}}\nList:\n- {{tags|join:\"\\n- \"}}\nCSV: {{tags|join:\", \"}}"
+	vars := map[string]any{"tags": []string{"go", "testing", "llm"}}
+
+	messages, err := Generate(template, vars)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "Tags: go,testing,llm\nList:\n- go\n- testing\n- llm\nCSV: go, testing, llm"
+	if messages[0].Content != expected {
+		t.Errorf("Expected %q, got %q", expected, messages[0].Content)
+	}
+
+	// Global separator only changes placeholders without join
+	messages, err = Generate(template, vars, GenerateOptions{ListSeparator: " / "})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = "Tags: go / testing / llm\nList:\n- go\n- testing\n- llm\nCSV: go, testing, llm"
+	if messages[0].Content != expected {
+		t.Errorf("Expected %q, got %q", expected, messages[0].Content)
+	}
+}
@@ -16,12 +16,26 @@ func (e *TemplateNotFoundError) Error() string {
 type VariableError struct {
 	Variable string
 	Template string
+
+	// MissingVars lists every missing variable in order of appearance
+	MissingVars []string
 }
 
 func (e *VariableError) Error() string {
 	return fmt.Sprintf("variable %q not found in template %q", e.Variable, e.Template)
 }
 
+// ValidationError indicates a variable value that doesn't match its validate.<name> pattern
+type ValidationError struct {
+	Variable string
+	Template string
+	Pattern  string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("variable %q in template %q does not match pattern %q", e.Variable, e.Template, e.Pattern)
+}
+
 // ImportError indicates a failure during template import
 type ImportError struct {
 	ImportPath string
@@ -33,6 +47,22 @@ func (e *ImportError) Error() string {
 	return fmt.Sprintf("failed to import %q in template %q: %v", e.ImportPath, e.Template, e.Cause)
 }
 
+// Unwrap returns the cause of the import failure
+func (e *ImportError) Unwrap() error {
+	return e.Cause
+}
+
+// TemplateSizeError indicates that a template file exceeds Config.MaxTemplateSize
+type TemplateSizeError struct {
+	Path  string
+	Size  int64
+	Limit int64
+}
+
+func (e *TemplateSizeError) Error() string {
+	return fmt.Sprintf("template %q is too large: %d bytes (limit %d)", e.Path, e.Size, e.Limit)
+}
+
 // ParseError indicates a template parsing error
 type ParseError struct {
 	Template string
@@ -46,3 +76,14 @@ func (e *ParseError) Error() string {
 	}
 	return fmt.Sprintf("parse error in template %q: %s", e.Template, e.Message)
 }
+
+// MetadataError indicates that template metadata violates the configured schema
+type MetadataError struct {
+	Template string
+	Key      string
+	Message  string
+}
+
+func (e *MetadataError) Error() string {
+	return fmt.Sprintf("invalid metadata %q in template %q: %s", e.Key, e.Template, e.Message)
+}
@@ -76,3 +76,16 @@ func TestParseError(t *testing.T) {
 		})
 	}
 }
+
+func TestMetadataError(t *testing.T) {
+	err := &MetadataError{
+		Template: "chat.md",
+		Key:      "temperature",
+		Message:  "value 3 is out of range [0, 2]",
+	}
+
+	expected := `invalid metadata "temperature" in template "chat.md": value 3 is out of range [0, 2]`
+	if err.Error() != expected {
+		t.Errorf("Expected error message %q, got %q", expected, err.Error())
+	}
+}
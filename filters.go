@@ -0,0 +1,164 @@
+package echotemplates
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// placeholderFilter transforms a resolved value, raw is the original
+// value when it is known and arg is the text after "filter:"
+type placeholderFilter func(value string, raw any, arg string) string
+
+// builtinFilters are the filters available in {{var|filter:arg}} placeholders
+var builtinFilters = map[string]placeholderFilter{
+	"join":       joinFilter,
+	"jsonstring": jsonStringFilter,
+	"mdcell":     mdCellFilter,
+}
+
+// filterCall is a filter reference parsed from a placeholder
+type filterCall struct {
+	name string
+	arg  string
+}
+
+// parsePlaceholder splits the inner part of {{...}} into the variable name,
+// the default value and trailing filters, e.g. {{tags|none|join:\n}}
+// Only known filters at the end are treated as filters, so default values
+// may still contain "|"
+func parsePlaceholder(inner string) (string, string, []filterCall) {
+	varName, rest, found := strings.Cut(inner, "|")
+	varName = strings.TrimSpace(varName)
+	if !found {
+		return varName, "", nil
+	}
+
+	// Peel known filters off the end, the remainder is the default value
+	var filters []filterCall
+	for found {
+		idx := strings.LastIndex(rest, "|")
+		call, ok := parseFilter(rest[idx+1:])
+		if !ok {
+			break
+		}
+		filters = append([]filterCall{call}, filters...)
+		if idx == -1 {
+			rest, found = "", false
+		} else {
+			rest = rest[:idx]
+		}
+	}
+
+	return varName, strings.TrimSpace(rest), filters
+}
+
+// parseFilter recognizes "name" or "name:arg" segments of known filters
+func parseFilter(segment string) (filterCall, bool) {
+	name, arg, _ := strings.Cut(strings.TrimLeft(segment, " \t"), ":")
+	name = strings.TrimSpace(name)
+	if _, ok := builtinFilters[name]; !ok {
+		return filterCall{}, false
+	}
+	return filterCall{name: name, arg: unquoteFilterArg(arg)}, true
+}
+
+// filterReferenceRegex matches a "word" or "word:arg" placeholder segment
+var filterReferenceRegex = regexp.MustCompile(`^\s*([A-Za-z_]\w*)\s*(:.*)?$`)
+
+// checkUnknownFilter reports a filter reference left at the end of a default value,
+// e.g. {{tags|jion:", "}} or {{name|uppr}}, which would otherwise be used as the default
+// Known filters are already peeled off, so any single word segment is an unknown filter;
+// defaults like {{role|helpful}} have to be written with a space or moved to front-matter
+func checkUnknownFilter(placeholder, defaultValue string) *ParseError {
+	segment := defaultValue[strings.LastIndex(defaultValue, "|")+1:]
+	match := filterReferenceRegex.FindStringSubmatch(segment)
+	if match == nil {
+		return nil
+	}
+	return &ParseError{
+		Message: fmt.Sprintf("unknown filter %q in {{%s}}", match[1], placeholder),
+	}
+}
+
+// unquoteFilterArg supports quoted arguments and \n, \t escapes
+func unquoteFilterArg(arg string) string {
+	if unquoted, err := strconv.Unquote(strings.TrimSpace(arg)); err == nil {
+		return unquoted
+	}
+	return strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(arg)
+}
+
+// applyFilters runs filters in order over the value
+func applyFilters(value string, raw any, filters []filterCall) string {
+	for _, call := range filters {
+		value = builtinFilters[call.name](value, raw, call.arg)
+		// Structure is lost once a filter produced a string
+		raw = nil
+	}
+	return value
+}
+
+// renderModes format list values as chosen by render.<var> front-matter
+var renderModes = map[string]func(list []string) string{
+	"csv":   func(list []string) string { return strings.Join(list, ", ") },
+	"lines": func(list []string) string { return strings.Join(list, "\n") },
+	"list": func(list []string) string {
+		return "- " + strings.Join(list, "\n- ")
+	},
+	"numbered": func(list []string) string {
+		var b strings.Builder
+		for i, item := range list {
+			if i > 0 {
+				b.WriteByte('\n')
+			}
+			b.WriteString(strconv.Itoa(i+1) + ". " + item)
+		}
+		return b.String()
+	},
+}
+
+// renderValue formats a list value with the render mode of its variable
+// Other values, and lists without a mode, keep their converted value
+func renderValue(value string, raw any, mode string) string {
+	list, ok := raw.([]string)
+	if !ok || len(list) == 0 {
+		return value
+	}
+	if render, ok := renderModes[mode]; ok {
+		return render(list)
+	}
+	return value
+}
+
+// joinFilter joins list values with the given separator
+func joinFilter(value string, raw any, arg string) string {
+	if list, ok := raw.([]string); ok {
+		return strings.Join(list, arg)
+	}
+	return value
+}
+
+// jsonStringFilter escapes the value for use inside a JSON string literal,
+// e.g. {"query": "{{query|jsonstring}}"}
+func jsonStringFilter(value string, raw any, arg string) string {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return value
+	}
+	encoded := strings.TrimSuffix(b.String(), "\n")
+	return encoded[1 : len(encoded)-1]
+}
+
+// mdCellReplacer escapes characters that would end a markdown table cell
+var mdCellReplacer = strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>")
+
+// mdCellFilter escapes pipes and turns line breaks into <br>, so the value stays in one table cell
+func mdCellFilter(value string, raw any, arg string) string {
+	return mdCellReplacer.Replace(value)
+}
@@ -0,0 +1,105 @@
+package echotemplates
+
+import (
+	"slices"
+	"strings"
+)
+
+// flattenedSuffix marks cache keys of import-resolved content, it can't appear in template paths
+const flattenedSuffix = "\x00flattened"
+
+// flattenedKey is the cache key of the import-resolved content of a template
+func flattenedKey(path string) string {
+	return path + flattenedSuffix
+}
+
+// isFlattenedKey reports whether a cache key holds import-resolved content
+func isFlattenedKey(key string) bool {
+	return strings.HasSuffix(key, flattenedSuffix)
+}
+
+// expandTemplateImports expands the imports of a template, reusing the content
+// flattened by Config.FlattenImports when neither the template nor an import changed
+func (e *templateEngine) expandTemplateImports(ctx *importContext, content string, template *parsedTemplate, name string) (string, error) {
+	cache := e.cacheState()
+	flatten := e.config.FlattenImports && cache != nil && len(template.imports) > 0 &&
+		!ctx.opts.DisableCache && ctx.trace == nil && ctx.opts.Locale == "" && !cacheDisabled(template.metadata)
+	if flatten {
+		if flattened, ok := e.flattenedContent(cache, name); ok {
+			return flattened, nil
+		}
+	}
+
+	content, err := e.expandImports(ctx, content, name)
+	if err != nil {
+		return "", err
+	}
+
+	// Only expansions that don't depend on variables, partials or failed imports are stable
+	if flatten && !ctx.volatile && len(ctx.failures) == 0 {
+		e.storeFlattened(cache, name, content, append(slices.Clone(ctx.deps), ctx.skipped...))
+	}
+	return content, nil
+}
+
+// flattenedContent returns the cached import-resolved content of a template
+func (e *templateEngine) flattenedContent(cache Cache, path string) (string, bool) {
+	key := flattenedKey(path)
+	cached, ok := cache.Get(key)
+	if !ok || cached == nil {
+		return "", false
+	}
+
+	current := append([]TemplateVersion{{Path: path, ModTime: cached.ModTime, ETag: cached.ETag}}, cached.Dependencies...)
+	if !e.versionsCurrent(current) {
+		cache.Invalidate(key)
+		return "", false
+	}
+	return cached.Content, true
+}
+
+// storeFlattened caches import-resolved content with the versions of its imports
+func (e *templateEngine) storeFlattened(cache Cache, path, content string, deps []string) {
+	info, err := e.source.Stat(path)
+	if err != nil {
+		return
+	}
+
+	entry := &CachedTemplate{Content: content, ModTime: info.ModTime, ETag: info.ETag}
+	entry.Dependencies = e.templateVersions(deps)
+	cache.Put(flattenedKey(path), entry)
+}
+
+// templateVersions returns the current versions of templates
+// Missing templates, such as skipped import alternatives, are recorded as absent
+// so that results depending on them are dropped once they appear
+func (e *templateEngine) templateVersions(paths []string) []TemplateVersion {
+	versions := make([]TemplateVersion, 0, len(paths))
+	for _, path := range paths {
+		info, err := e.source.Stat(path)
+		if err != nil {
+			versions = append(versions, TemplateVersion{Path: path, Absent: true})
+			continue
+		}
+		versions = append(versions, TemplateVersion{Path: path, ModTime: info.ModTime, ETag: info.ETag})
+	}
+	return versions
+}
+
+// versionsCurrent reports whether templates still match their recorded versions:
+// present ones are unchanged and not shadowed by a partial, absent ones still missing
+func (e *templateEngine) versionsCurrent(versions []TemplateVersion) bool {
+	for _, version := range versions {
+		info, err := e.source.Stat(version.Path)
+		if version.Absent {
+			if err == nil {
+				return false
+			}
+			continue
+		}
+		if err != nil || version.isStale(info.ModTime, info.ETag) || e.hasPartial(version.Path) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+package echotemplates
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// resolveCountingSource counts import resolutions of the wrapped mock source
+type resolveCountingSource struct {
+	*MockSource
+	resolves atomic.Int32
+}
+
+func (s *resolveCountingSource) ResolveImport(importPath, currentPath string) string {
+	s.resolves.Add(1)
+	return s.MockSource.ResolveImport(importPath, currentPath)
+}
+
+func TestFlattenImports(t *testing.T) {
+	mock := NewMockSource(map[string]string{
+		"main.md":            "@system:\n{{@partials/header}}\n\n@user:\n{{question}}",
+		"dynamic.md":         "@system:\n{{@partials/{{part}}}}",
+		"partials/header.md": "You are {{role|helpful}}.\n{{@partials/footer}}",
+		"partials/footer.md": "Be brief.",
+	})
+	source := &resolveCountingSource{MockSource: mock}
+
+	engine, err := New(Config{Source: source, FlattenImports: true, CacheCheckInterval: -1})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	generate := func(name string, vars map[string]any, expected string) {
+		t.Helper()
+		messages, err := engine.Generate(name, vars)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if messages[0].Content != expected {
+			t.Errorf("Expected %q, got %q", expected, messages[0].Content)
+		}
+	}
+
+	generate("main", map[string]any{"question": "Hi"}, "You are helpful.\nBe brief.")
+	if n := source.resolves.Load(); n != 2 {
+		t.Fatalf("Expected 2 import resolutions, got %d", n)
+	}
+
+	// The second generation uses the flattened content
+	generate("main", map[string]any{"question": "Hi", "role": "precise"}, "You are precise.\nBe brief.")
+	if n := source.resolves.Load(); n != 2 {
+		t.Errorf("Expected imports to be resolved once, got %d resolutions", n)
+	}
+
+	// A changed nested import invalidates the flattened content
+	mock.templates["partials/footer.md"] = "Be detailed."
+	generate("main", map[string]any{"question": "Hi"}, "You are helpful.\nBe detailed.")
+	if n := source.resolves.Load(); n != 4 {
+		t.Errorf("Expected imports to be resolved again, got %d resolutions", n)
+	}
+
+	// Dynamic imports depend on variables and are resolved every time
+	source.resolves.Store(0)
+	generate("dynamic", map[string]any{"part": "footer"}, "Be detailed.")
+	generate("dynamic", map[string]any{"part": "header"}, "You are helpful.\nBe detailed.")
+	if n := source.resolves.Load(); n != 3 {
+		t.Errorf("Expected dynamic imports to be resolved each time, got %d resolutions", n)
+	}
+}
+
+func TestFlattenImportAlternatives(t *testing.T) {
+	mock := NewMockSource(map[string]string{
+		"main.md":  "@system:\n{{@custom/rules|rules}}",
+		"rules.md": "default rules",
+	})
+
+	tests := []struct {
+		name    string
+		flatten bool
+	}{
+		{"plain", false},
+		{"flattened", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delete(mock.templates, "custom/rules.md")
+			engine, err := New(Config{Source: mock, FlattenImports: tt.flatten, CacheCheckInterval: -1})
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+
+			messages, err := engine.Generate("main", nil)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if messages[0].Content != "default rules" {
+				t.Errorf("Expected the fallback, got %q", messages[0].Content)
+			}
+
+			// The preferred alternative is used once it appears
+			mock.templates["custom/rules.md"] = "custom rules"
+			messages, err = engine.Generate("main", nil)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if messages[0].Content != "custom rules" {
+				t.Errorf("Expected the added alternative, got %q", messages[0].Content)
+			}
+		})
+	}
+}
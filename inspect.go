@@ -0,0 +1,316 @@
+package echotemplates
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TemplateReport describes a template, as returned by Inspect
+type TemplateReport struct {
+	// Name is the template name, Path the source path it was loaded from
+	Name string
+	Path string
+
+	// Version is the version front-matter key, empty when not declared
+	Version string
+
+	// Metadata is the effective metadata without engine-internal keys
+	Metadata map[string]any
+
+	// Variables used by the template and its imports, sorted by name
+	Variables []VariableReport
+
+	// Imports lists the transitive imports in order of first use
+	Imports []string
+
+	// Size is the byte size of the template body with imports expanded,
+	// EstimatedTokens its token estimate (Config.TokenCounter or characters/4)
+	Size            int
+	EstimatedTokens int
+
+	// Fingerprint changes whenever the template or an import changes
+	Fingerprint string
+
+	// Lint lists potential problems found by LintTemplate
+	Lint []LintIssue
+
+	// Warnings lists validation errors and failed imports
+	Warnings []string
+}
+
+// Region is a {{#region Name}}...{{#endregion}} span of a template,
+// used by editors for folding and navigation
+type Region struct {
+	Name string
+
+	// StartLine and EndLine are the lines of the opening and closing markers in the template file
+	StartLine int
+	EndLine   int
+}
+
+// VariableReport describes a variable of a template
+type VariableReport struct {
+	Name string
+
+	// Required is set when the variable has no default, fallback or global value
+	Required bool
+
+	// Default is the value used when the variable is not passed, in order of precedence:
+	// front-matter default, global variable, inline {{name|default}}, front-matter fallback
+	Default string
+}
+
+// Inspect returns a report of everything known about a template without generating it
+// Validation problems are reported as warnings, only a missing template is an error
+func (e *templateEngine) Inspect(name string) (*TemplateReport, error) {
+	path := e.withExtension(e.resolveAlias(name))
+
+	template, err := e.loadTemplate(path, GenerateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &TemplateReport{Name: e.templateName(path), Path: path}
+	addWarning := func(err error) {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, err := range joined.Unwrap() {
+				report.Warnings = append(report.Warnings, err.Error())
+			}
+			return
+		}
+		report.Warnings = append(report.Warnings, err.Error())
+	}
+
+	if err := e.ValidateTemplate(path); err != nil {
+		addWarning(err)
+	}
+
+	metadata, err := e.templateMetadata(template, path, GenerateOptions{})
+	if err != nil {
+		metadata = template.metadata
+	}
+	report.Metadata = make(map[string]any, len(metadata))
+	for k, v := range metadata {
+		report.Metadata[k] = v
+	}
+	for _, key := range internalMetadataKeys {
+		delete(report.Metadata, key)
+	}
+	report.Version, _ = e.GetTemplateVersion(path)
+
+	// Expand imports leniently, so broken imports don't hide the rest of the report
+	ctx := &importContext{vars: make(map[string]string), opts: GenerateOptions{}}
+	content, err := e.expandImports(ctx, template.content, path)
+	if err != nil {
+		addWarning(err)
+		content = template.content
+	}
+	for _, failure := range ctx.failures {
+		addWarning(failure)
+	}
+	report.Imports = ctx.deps
+	content = stripBlockMarkers(content)
+	report.Size = len(content)
+	if e.config.TokenCounter != nil {
+		report.EstimatedTokens = e.config.TokenCounter(content)
+	} else {
+		report.EstimatedTokens = estimateTokensHeuristic(content)
+	}
+
+	variables, err := e.GetTemplateVariables(path)
+	if err != nil {
+		// Fall back to the variables of the leniently expanded content
+		variables = lineVariables(content)
+	}
+	defaults, _ := metadata["defaults"].(map[string]string)
+	fallbacks, _ := metadata["fallbacks"].(map[string]string)
+	inline := inlineDefaults(content)
+	seen := make(map[string]bool)
+	for _, variable := range variables {
+		if seen[variable] {
+			continue
+		}
+		seen[variable] = true
+
+		info := VariableReport{Name: variable}
+		if value, ok := defaults[variable]; ok {
+			info.Default = value
+		} else if value, ok := e.config.GlobalVars[variable]; ok {
+			info.Default = toString(value)
+		} else if value, ok := inline[variable]; ok {
+			info.Default = value
+		} else if value, ok := fallbacks[variable]; ok {
+			info.Default = value
+		} else {
+			info.Required = true
+		}
+		report.Variables = append(report.Variables, info)
+	}
+	sort.Slice(report.Variables, func(i, j int) bool {
+		return report.Variables[i].Name < report.Variables[j].Name
+	})
+
+	report.Fingerprint, _ = e.Fingerprint(path)
+	report.Lint, _ = e.LintTemplate(path)
+
+	return report, nil
+}
+
+// jsonSchemaDraft is the JSON Schema dialect of VariablesJSONSchema
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchemaTypes are the accepted type.<name> hints
+var jsonSchemaTypes = []string{"string", "number", "integer", "boolean"}
+
+// VariablesJSONSchema returns a JSON Schema object with a property per template variable
+// Variables without a default are required, validate.<name> patterns become "pattern"
+// and a type.<name> front-matter hint (number, integer or boolean) sets the type
+func (e *templateEngine) VariablesJSONSchema(name string) ([]byte, error) {
+	report, err := e.Inspect(name)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := e.loadTemplate(report.Path, GenerateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := e.templateMetadata(template, report.Path, GenerateOptions{})
+	if err != nil {
+		metadata = template.metadata
+	}
+	patterns, _ := metadata["validate"].(map[string]string)
+
+	properties := make(map[string]any)
+	required := []string{}
+	for _, variable := range report.Variables {
+		// Implicit variables are always provided by the engine
+		if variable.Name == templateNameVar || variable.Name == templatePathVar {
+			continue
+		}
+
+		kind := variableTypeHint(metadata, variable.Name)
+		property := map[string]any{"type": kind}
+		if pattern, ok := patterns[variable.Name]; ok && kind == "string" {
+			property["pattern"] = pattern
+		}
+		if variable.Required {
+			required = append(required, variable.Name)
+		} else {
+			property["default"] = schemaDefault(variable.Default, kind)
+		}
+		properties[variable.Name] = property
+	}
+
+	schema := map[string]any{
+		"$schema":    jsonSchemaDraft,
+		"title":      report.Name,
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+	if description, ok := metadata["description"].(string); ok && description != "" {
+		schema["description"] = description
+	}
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// variableTypeHint returns the type.<name> hint of a variable, string by default
+func variableTypeHint(metadata map[string]any, name string) string {
+	types, _ := metadata["type_hints"].(map[string]string)
+	if kind, ok := types[name]; ok {
+		return kind
+	}
+	return "string"
+}
+
+// schemaDefault converts a default value to the JSON type of its variable
+func schemaDefault(value, kind string) any {
+	switch kind {
+	case "number":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	case "integer":
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// inlineDefaults returns the {{name|default}} values of content, the first one wins
+func inlineDefaults(content string) map[string]string {
+	defaults := make(map[string]string)
+	content = rawPlaceholderRegex.ReplaceAllString(escapeBraces(content), "")
+	for _, match := range placeholderRegex.FindAllStringSubmatch(content, -1) {
+		inner := strings.TrimSpace(match[1])
+		if !strings.Contains(inner, "|") || strings.ContainsAny(inner[:1], "@#/?") {
+			continue
+		}
+		name, value, filters := parsePlaceholder(inner)
+		if _, ok := defaults[name]; ok || (value == "" && len(filters) > 0) {
+			continue
+		}
+		defaults[name] = value
+	}
+	return defaults
+}
+
+// GetRegions returns the regions of a template in order of their opening marker
+// Unbalanced markers are reported as a *ParseError
+func (e *templateEngine) GetRegions(name string) ([]Region, error) {
+	text, err := e.RawTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+	offset := frontMatterLines(text, e.config.FrontMatterDelimiter)
+	return scanRegions(text, offset, e.withExtension(e.resolveAlias(name)))
+}
+
+// GetUnusedImports returns imports of a template that are dropped by conditionals
+// when only front-matter defaults and global vars are set, e.g. {{@extra}} inside
+// {{#if verbose}}; imports are reported as written, in order of appearance
+func (e *templateEngine) GetUnusedImports(name string) ([]string, error) {
+	path := e.withExtension(e.resolveAlias(name))
+	opts := e.config.DefaultOptions
+
+	template, err := e.loadTemplate(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := e.templateMetadata(template, path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	separator := opts.ListSeparator
+	if separator == "" {
+		separator = defaultListSeparator
+	}
+	vars, _ := e.mergeVars(metadata, nil, nil, separator)
+	if opts.Now == nil {
+		opts.Now = e.config.Clock
+	}
+
+	content, err := processConditionals(template.content, vars, opts)
+	if err != nil {
+		return nil, err
+	}
+	used := extractImports(content)
+
+	var unused []string
+	for _, importExpr := range template.imports {
+		if !slices.Contains(used, importExpr) && !slices.Contains(unused, importExpr) {
+			unused = append(unused, importExpr)
+		}
+	}
+	return unused, nil
+}
@@ -0,0 +1,162 @@
+package echotemplates
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"support/reply.md": "---\nmodel: gpt-4\nversion: 2.0\ndefault.tone: friendly\nfallback.customer: there\n---\n" +
+			"@system:\n{{@shared/rules}}\nYou are {{tone}}.\n\n@user:\nHi {{customer}}, about {{topic|your request}}: {{question}}",
+		"shared/rules.md":  "Answer in {{language}}.\n{{@shared/footer}}",
+		"shared/footer.md": "Sign as {{app_name}}.",
+		"broken.md":        "@user:\n{{@missing}} {{name}}",
+	})
+
+	engine, err := New(Config{Source: source, GlobalVars: map[string]any{"app_name": "Helpdesk"}})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	report, err := engine.Inspect("support/reply")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if report.Name != "support/reply" || report.Path != "support/reply.md" || report.Version != "2.0" {
+		t.Errorf("Unexpected identity %q %q %q", report.Name, report.Path, report.Version)
+	}
+	if !reflect.DeepEqual(report.Metadata, map[string]any{"model": "gpt-4", "version": "2.0"}) {
+		t.Errorf("Unexpected metadata %v", report.Metadata)
+	}
+	if !reflect.DeepEqual(report.Imports, []string{"shared/rules.md", "shared/footer.md"}) {
+		t.Errorf("Unexpected imports %v", report.Imports)
+	}
+
+	expected := []VariableReport{
+		{Name: "app_name", Default: "Helpdesk"},
+		{Name: "customer", Default: "there"},
+		{Name: "language", Required: true},
+		{Name: "question", Required: true},
+		{Name: "tone", Default: "friendly"},
+		{Name: "topic", Default: "your request"},
+	}
+	if !reflect.DeepEqual(report.Variables, expected) {
+		t.Errorf("Expected variables %v, got %v", expected, report.Variables)
+	}
+
+	if report.Size == 0 || report.EstimatedTokens != (report.Size+3)/4 {
+		t.Errorf("Unexpected size %d and tokens %d", report.Size, report.EstimatedTokens)
+	}
+	fingerprint, _ := engine.Fingerprint("support/reply")
+	if report.Fingerprint != fingerprint {
+		t.Errorf("Expected fingerprint %q, got %q", fingerprint, report.Fingerprint)
+	}
+	if len(report.Lint) != 1 || report.Lint[0].Rule != LintSystemPlaceholder {
+		t.Errorf("Expected a system placeholder lint issue, got %v", report.Lint)
+	}
+	if len(report.Warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", report.Warnings)
+	}
+
+	// Broken templates are still reported
+	report, err = engine.Inspect("broken")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(report.Warnings) == 0 || !strings.Contains(report.Warnings[0], "missing") {
+		t.Errorf("Expected a warning for the missing import, got %v", report.Warnings)
+	}
+	if !reflect.DeepEqual(report.Variables, []VariableReport{{Name: "name", Required: true}}) {
+		t.Errorf("Unexpected variables %v", report.Variables)
+	}
+
+	if _, err := engine.Inspect("nope"); err == nil {
+		t.Error("Expected error for a missing template")
+	}
+}
+
+func TestVariablesJSONSchema(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"signup.md": `---
+description: Sign-up assistant
+default.tone: friendly
+type.age: integer
+type.score: number
+validate.email: ^[^@]+@[^@]+$
+---
+@system:
+Be {{tone}}. Score at least {{score|0.5}}.
+@user:
+{{email}} is {{age}} years old`,
+		"bad.md":  "---\ntype.age: date\n---\n@user:\n{{age}}",
+		"toml.md": "+++\n[type]\nactive = \"boolean\"\n[defaults]\nactive = \"true\"\n+++\n@user:\n{{active}}",
+	})
+
+	engine, err := New(Config{Source: source})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	data, err := engine.VariablesJSONSchema("signup")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var schema struct {
+		Schema      string                    `json:"$schema"`
+		Title       string                    `json:"title"`
+		Description string                    `json:"description"`
+		Type        string                    `json:"type"`
+		Properties  map[string]map[string]any `json:"properties"`
+		Required    []string                  `json:"required"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+
+	if schema.Type != "object" || schema.Title != "signup" || schema.Description != "Sign-up assistant" || schema.Schema == "" {
+		t.Errorf("Unexpected schema header: %s", data)
+	}
+	if !reflect.DeepEqual(schema.Required, []string{"age", "email"}) {
+		t.Errorf("Expected age and email to be required, got %v", schema.Required)
+	}
+
+	expected := map[string]map[string]any{
+		"age":   {"type": "integer"},
+		"email": {"type": "string", "pattern": "^[^@]+@[^@]+$"},
+		"score": {"type": "number", "default": 0.5},
+		"tone":  {"type": "string", "default": "friendly"},
+	}
+	if !reflect.DeepEqual(schema.Properties, expected) {
+		t.Errorf("Expected properties %v, got %v", expected, schema.Properties)
+	}
+
+	t.Run("toml type table", func(t *testing.T) {
+		data, err := engine.VariablesJSONSchema("toml")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !strings.Contains(string(data), `"default": true`) || !strings.Contains(string(data), `"type": "boolean"`) {
+			t.Errorf("Expected boolean property, got %s", data)
+		}
+	})
+
+	t.Run("unknown type", func(t *testing.T) {
+		var parseErr *ParseError
+		_, err := engine.Generate("bad", map[string]any{"age": 3}, GenerateOptions{StrictMode: true})
+		if !errors.As(err, &parseErr) || !strings.Contains(err.Error(), "type.age") {
+			t.Errorf("Expected ParseError for type.age, got %v", err)
+		}
+	})
+
+	t.Run("missing template", func(t *testing.T) {
+		if _, err := engine.VariablesJSONSchema("missing"); err == nil {
+			t.Error("Expected error for missing template")
+		}
+	})
+}
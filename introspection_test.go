@@ -1,10 +1,13 @@
 package echotemplates
 
 import (
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"reflect"
 	"sort"
+	"sync/atomic"
 	"testing"
 )
 
@@ -133,6 +136,54 @@ I'll help you with {{topic}}.`,
 		}
 	})
 
+	t.Run("AllTemplateVariables", func(t *testing.T) {
+		all, err := engine.AllTemplateVariables()
+		if err != nil {
+			t.Fatalf("AllTemplateVariables() error = %v", err)
+		}
+
+		expected := map[string][]string{
+			"simple":          {"query", "role"},
+			"with-vars":       {"raw_content", "role", "style"},
+			"nested/template": {"var1", "var2"},
+			"with-import":     {"query", "role", "topic"},
+		}
+
+		if !reflect.DeepEqual(all, expected) {
+			t.Errorf("AllTemplateVariables() = %v, want %v", all, expected)
+		}
+	})
+
+	t.Run("FindTemplates", func(t *testing.T) {
+		tests := []struct {
+			pattern  string
+			expected []string
+		}{
+			{"with-*", []string{"with-import", "with-vars"}},
+			{"*", []string{"simple", "with-import", "with-vars"}},
+			{"nested/*.md", []string{"nested/template"}},
+			{"**/template", []string{"nested/template"}},
+			{"**", []string{"nested/template", "simple", "with-import", "with-vars"}},
+			{"other/**", nil},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.pattern, func(t *testing.T) {
+				templates, err := engine.FindTemplates(tt.pattern)
+				if err != nil {
+					t.Fatalf("FindTemplates(%q) error = %v", tt.pattern, err)
+				}
+				if !reflect.DeepEqual(templates, tt.expected) {
+					t.Errorf("FindTemplates(%q) = %v, want %v", tt.pattern, templates, tt.expected)
+				}
+			})
+		}
+
+		if _, err := engine.FindTemplates("[invalid"); err == nil {
+			t.Error("Expected error for invalid pattern")
+		}
+	})
+
 	t.Run("ListTemplates", func(t *testing.T) {
 		templates, err := engine.ListTemplates()
 		if err != nil {
@@ -151,3 +202,324 @@ I'll help you with {{topic}}.`,
 		}
 	})
 }
+
+func TestListTemplatesByMetadata(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"support.md": "---\nmodel: gpt-4\ntemperature: 0.5\ntags: [support, chat]\n---\n@user:\nHi",
+		"summary.md": "---\nmodel: gpt-4o-mini\ntags: [docs]\nmax_tokens: 100\n---\n@user:\nSummarize",
+		"plain.md":   "@user:\nHello",
+		"typed.md":   "---\ntype: chat\nrender: markdown\ntype.name: string\n---\n@user:\nHi {{name}}",
+	})
+
+	engine, err := New(Config{
+		Source:          source,
+		DefaultMetadata: map[string]any{"temperature": 0.7},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		value    any
+		expected []string
+	}{
+		{"model", "model", "gpt-4", []string{"support"}},
+		{"tag", "tags", "docs", []string{"summary"}},
+		{"shared tag", "tags", "chat", []string{"support"}},
+		{"int as float", "max_tokens", 100.0, []string{"summary"}},
+		{"default metadata", "temperature", 0.7, []string{"plain", "summary", "typed"}},
+		{"type key", "type", "chat", []string{"typed"}},
+		{"render key", "render", "markdown", []string{"typed"}},
+		{"whole list", "tags", []string{"docs"}, []string{"summary"}},
+		{"map value", "defaults", map[string]string{"x": "1"}, nil},
+		{"no match", "model", "claude", nil},
+		{"missing key", "owner", "me", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			templates, err := engine.ListTemplatesByMetadata(tt.key, tt.value)
+			if err != nil {
+				t.Fatalf("ListTemplatesByMetadata() error = %v", err)
+			}
+			if !reflect.DeepEqual(templates, tt.expected) {
+				t.Errorf("ListTemplatesByMetadata(%q, %v) = %v, want %v", tt.key, tt.value, templates, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetTemplateVersion(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"versioned.md": "---\nmodel: gpt-4\nversion: 1.10\n---\n@user:\nHi",
+		"plain.md":     "@user:\nHello",
+	})
+
+	engine, err := New(Config{Source: source})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"with version", "versioned", "1.10"},
+		{"without version", "plain", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, err := engine.GetTemplateVersion(tt.template)
+			if err != nil {
+				t.Fatalf("GetTemplateVersion() error = %v", err)
+			}
+			if version != tt.expected {
+				t.Errorf("GetTemplateVersion(%q) = %q, want %q", tt.template, version, tt.expected)
+			}
+		})
+	}
+
+	// The version is returned unchanged in metadata
+	_, metadata, err := engine.GenerateWithMetadata("versioned", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if metadata["version"] != "1.10" {
+		t.Errorf("Expected version '1.10' in metadata, got %#v", metadata["version"])
+	}
+
+	if _, err := engine.GetTemplateVersion("missing"); err == nil {
+		t.Error("Expected error for missing template")
+	}
+}
+
+func TestRawTemplate(t *testing.T) {
+	tmpDir := t.TempDir()
+	text := "---\nmodel: gpt-4\n---\n@system:\nYou are {{role}}.\n<!-- note -->\n"
+	os.MkdirAll(filepath.Join(tmpDir, "chat"), 0755)
+	if err := os.WriteFile(filepath.Join(tmpDir, "chat", "assistant.md"), []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := NewFileSystemSource(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := New(Config{Source: source, StripHTMLComments: true})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	for _, name := range []string{"chat/assistant", "chat/assistant.md"} {
+		raw, err := engine.RawTemplate(name)
+		if err != nil {
+			t.Fatalf("RawTemplate(%q) error = %v", name, err)
+		}
+		if raw != text {
+			t.Errorf("RawTemplate(%q) = %q, want %q", name, raw, text)
+		}
+	}
+
+	_, err = engine.RawTemplate("missing")
+	var notFound *TemplateNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Errorf("Expected TemplateNotFoundError, got %v", err)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"main.md":         "@system:\n{{@shared/rules}}\n\n@user:\n{{question}}",
+		"other.md":        "@system:\n{{@shared/rules}}",
+		"shared/rules.md": "Be brief.\n{{@shared/tone}}",
+		"shared/tone.md":  "Be friendly.",
+		"unrelated.md":    "Hello",
+	})
+
+	engine, err := New(Config{Source: source})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	fingerprint := func(name string) string {
+		t.Helper()
+		value, err := engine.Fingerprint(name)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return value
+	}
+
+	initial := fingerprint("main")
+	if initial != fingerprint("main") {
+		t.Error("Expected a stable fingerprint")
+	}
+	if initial == fingerprint("other") {
+		t.Error("Expected different templates to have different fingerprints")
+	}
+
+	// Unrelated files don't change the fingerprint
+	source.templates["unrelated.md"] = "Hello again"
+	if fingerprint("main") != initial {
+		t.Error("Expected fingerprint to ignore unrelated files")
+	}
+
+	// Nested imports do
+	source.templates["shared/tone.md"] = "Be formal."
+	if fingerprint("main") == initial {
+		t.Error("Expected fingerprint to change with a nested import")
+	}
+
+	if _, err := engine.Fingerprint("missing"); err == nil {
+		t.Error("Expected error for a missing template")
+	}
+}
+
+func TestGetRegions(t *testing.T) {
+	templates := map[string]string{
+		"long.md":     "---\nmodel: gpt-4\n---\n@system:\n{{#region Persona}}\nYou are {{role}}.\n{{#region Tone}}\nBe brief.\n{{#endregion}}\n{{#endregion}}\n@user:\n{{#region Question}}\n{{question}}\n{{#endregion}}",
+		"unclosed.md": "@user:\n{{#region Open}}\nHi",
+		"stray.md":    "@user:\nHi\n{{#endregion}}",
+	}
+	engine, err := New(Config{Source: NewMockSource(templates)})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	regions, err := engine.GetRegions("long")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []Region{
+		{Name: "Persona", StartLine: 5, EndLine: 10},
+		{Name: "Tone", StartLine: 7, EndLine: 9},
+		{Name: "Question", StartLine: 12, EndLine: 14},
+	}
+	if !reflect.DeepEqual(regions, expected) {
+		t.Errorf("Expected regions %v, got %v", expected, regions)
+	}
+
+	// Markers are not part of the output or the variables
+	messages, err := engine.Generate("long", map[string]any{"role": "a guide", "question": "Why?"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(messages) != 2 || messages[0].Content != "You are a guide.\nBe brief." || messages[1].Content != "Why?" {
+		t.Errorf("Unexpected messages %v", messages)
+	}
+	variables, err := engine.GetTemplateVariables("long")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(variables, []string{"question", "role"}) {
+		t.Errorf("Expected [question role], got %v", variables)
+	}
+
+	for name, line := range map[string]int{"unclosed": 2, "stray": 3} {
+		_, err := engine.GetRegions(name)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || parseErr.Line != line {
+			t.Errorf("Expected ParseError at line %d for %s, got %v", line, name, err)
+		}
+	}
+}
+
+func TestGetUnusedImports(t *testing.T) {
+	templates := map[string]string{
+		"chat.md": "---\ndefault.verbose: false\ndefault.verbose[chatty]: true\ndefault_selector: mode\ndefault.tone: formal\n---\n@system:\n{{@persona}}\n" +
+			"{{#if verbose}}\n{{@details}}\n{{@persona}}\n{{/if}}\n" +
+			"{{#if tone == \"casual\"}}\n{{@slang}}\n{{else}}\n{{@etiquette}}\n{{/if}}\n" +
+			"{{?beta}}\n{{@beta/notes}}\n{{/beta}}\n@user:\n{{question}}",
+		"persona.md":    "You are helpful.",
+		"details.md":    "Explain everything.",
+		"slang.md":      "Use slang.",
+		"etiquette.md":  "Be polite.",
+		"beta/notes.md": "Beta.",
+	}
+
+	tests := []struct {
+		name     string
+		config   Config
+		expected []string
+	}{
+		{"defaults", Config{}, []string{"details", "slang", "beta/notes"}},
+		{"global vars", Config{GlobalVars: map[string]any{"beta": true}}, []string{"details", "slang"}},
+		{"conditional default", Config{GlobalVars: map[string]any{"mode": "chatty"}}, []string{"slang", "beta/notes"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Source = NewMockSource(templates)
+			engine, err := New(tt.config)
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+
+			unused, err := engine.GetUnusedImports("chat")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(unused, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, unused)
+			}
+		})
+	}
+}
+
+// openCountingSource counts the templates opened from the wrapped mock source
+type openCountingSource struct {
+	*MockSource
+	opens atomic.Int32
+}
+
+func (s *openCountingSource) Open(path string) (io.ReadCloser, error) {
+	s.opens.Add(1)
+	return s.MockSource.Open(path)
+}
+
+func TestTemplateVariablesMemo(t *testing.T) {
+	source := &openCountingSource{MockSource: NewMockSource(map[string]string{
+		"main.md":   "{{@shared}} {{question}}",
+		"alt.md":    "{{@custom|shared}}",
+		"shared.md": "{{tone}}",
+	})}
+
+	// Dev mode parses on every load, so only the memo avoids opening templates
+	engine, err := New(Config{Source: source, DevMode: true})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	steps := []struct {
+		name     string
+		change   func()
+		expected map[string][]string
+		opens    bool
+	}{
+		{"first call", func() {}, map[string][]string{"main": {"question", "tone"}, "alt": {"tone"}, "shared": {"tone"}}, true},
+		{"repeat call", func() {}, map[string][]string{"main": {"question", "tone"}, "alt": {"tone"}, "shared": {"tone"}}, false},
+		{"changed import", func() { source.templates["shared.md"] = "{{style}}" },
+			map[string][]string{"main": {"question", "style"}, "alt": {"style"}, "shared": {"style"}}, true},
+		{"added alternative", func() { source.templates["custom.md"] = "{{persona}}" },
+			map[string][]string{"main": {"question", "style"}, "alt": {"persona"}, "custom": {"persona"}, "shared": {"style"}}, true},
+	}
+
+	for _, step := range steps {
+		step.change()
+		opens := source.opens.Load()
+		all, err := engine.AllTemplateVariables()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+		if !reflect.DeepEqual(all, step.expected) {
+			t.Errorf("%s: expected %v, got %v", step.name, step.expected, all)
+		}
+		if opened := source.opens.Load() > opens; opened != step.opens {
+			t.Errorf("%s: expected templates to be opened: %v, got %v", step.name, step.opens, opened)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package echotemplates
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lint rules reported by LintTemplate
+const (
+	// LintSystemPlaceholder flags {{var}} placeholders inside @system: sections,
+	// where user-controlled values may allow prompt injection
+	LintSystemPlaceholder = "system-placeholder"
+)
+
+// LintIssue is a potential problem found by LintTemplate
+// Unlike validation errors, issues don't prevent generation
+type LintIssue struct {
+	Template string
+	Line     int
+	Rule     string
+	Message  string
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", i.Template, i.Line, i.Rule, i.Message)
+}
+
+// lintSystemPlaceholders reports {{var}} placeholders in system sections
+// Role markers are tracked line by line, text before the first marker is a user message
+func lintSystemPlaceholders(content string) []LintIssue {
+	var issues []LintIssue
+	inSystem := false
+
+	for i, line := range strings.Split(content, "\n") {
+		if role, rest, ok := parseRoleMarker(line); ok {
+			inSystem = strings.EqualFold(role, "system")
+			line = rest
+		}
+		if !inSystem {
+			continue
+		}
+
+		for _, name := range lineVariables(line) {
+			issues = append(issues, LintIssue{
+				Line:    i + 1,
+				Rule:    LintSystemPlaceholder,
+				Message: fmt.Sprintf("variable %q is interpolated into a system section, review it for prompt injection", name),
+			})
+		}
+	}
+	return issues
+}
+
+// lineVariables returns the variables of regular {{var}} placeholders in a line
+// Raw placeholders, imports, tags, {{now:...}} and implicit variables are skipped
+func lineVariables(line string) []string {
+	if hasEscapes(line) {
+		line = escapeBraces(line)
+	}
+
+	var names []string
+	for i := 0; i < len(line); {
+		next := strings.Index(line[i:], "{{")
+		if next == -1 {
+			break
+		}
+		i += next
+
+		if _, end, ok := scanPlaceholder(line, i, 3); ok {
+			i = end
+			continue
+		}
+		inner, end, ok := scanPlaceholder(line, i, 2)
+		if !ok {
+			i++
+			continue
+		}
+		i = end
+
+		inner = strings.TrimSpace(inner)
+		if inner == "" || strings.ContainsAny(inner[:1], "@#/?") || inner == "else" ||
+			strings.HasPrefix(inner, nowPrefix) || strings.HasPrefix(inner, imagePrefix) {
+			continue
+		}
+		name, _, _ := parsePlaceholder(inner)
+		if name == templateNameVar || name == templatePathVar {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
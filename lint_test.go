@@ -0,0 +1,72 @@
+package echotemplates
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLintSystemPlaceholders(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"system.md": "---\nmodel: gpt-4\n---\n@system:\nYou help {{user_name}} with {{topic|upper}}.\n\n@user:\n{{question}}",
+		"user.md":   "@system:\nYou are helpful.\n\n@user:\nHello {{name}}",
+		"inline.md": "@system: Talk like {{persona}}\n@user:\nHi",
+		"skipped.md": "@system:\n{{{context}}} {{@common/rules}} {{now:2006-01-02}} \\{{literal\\}}\n" +
+			"{{#if formal}}Be formal.{{else}}Be casual.{{/if}} Prompt {{__template__}}",
+		"plain.md": "No roles, {{name}} is a user message",
+	})
+
+	engine, err := New(Config{Source: source})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected []LintIssue
+	}{
+		{
+			name:     "variables in system block",
+			template: "system",
+			expected: []LintIssue{
+				{Template: "system.md", Line: 5, Rule: LintSystemPlaceholder, Message: `variable "user_name" is interpolated into a system section, review it for prompt injection`},
+				{Template: "system.md", Line: 5, Rule: LintSystemPlaceholder, Message: `variable "topic" is interpolated into a system section, review it for prompt injection`},
+			},
+		},
+		{
+			name:     "variable in user block",
+			template: "user",
+		},
+		{
+			name:     "inline system content",
+			template: "inline",
+			expected: []LintIssue{
+				{Template: "inline.md", Line: 1, Rule: LintSystemPlaceholder, Message: `variable "persona" is interpolated into a system section, review it for prompt injection`},
+			},
+		},
+		{
+			name:     "raw placeholders, imports and tags",
+			template: "skipped",
+		},
+		{
+			name:     "no roles",
+			template: "plain",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues, err := engine.LintTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(issues, tt.expected) {
+				t.Errorf("LintTemplate(%q) = %v, want %v", tt.template, issues, tt.expected)
+			}
+		})
+	}
+
+	if _, err := engine.LintTemplate("missing"); err == nil {
+		t.Error("Expected error for missing template")
+	}
+}
@@ -0,0 +1,239 @@
+package echotemplates
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// manifestFiles are the names checked at the source root, in order
+var manifestFiles = []string{"manifest.yaml", ".echo-templates.yaml"}
+
+// Manifest describes a template library
+// It is read from manifest.yaml or .echo-templates.yaml at the source root:
+//
+//	aliases:
+//	  greet: greetings/hello
+//	globals:
+//	  company: ACME
+//	allowed_roles: [system, user, developer]
+//	required_metadata:
+//	  - model
+//	  - description
+type Manifest struct {
+	// Aliases map short template names to template paths
+	Aliases map[string]string
+
+	// Globals are variables available to every template
+	Globals map[string]any
+
+	// AllowedRoles lists the accepted @role: markers
+	AllowedRoles []string
+
+	// RequiredMetadata lists keys every template must declare
+	RequiredMetadata []string
+}
+
+// loadManifest reads the manifest from the source root, returning nil if there is none
+func loadManifest(source TemplateSource) (*Manifest, error) {
+	for _, name := range manifestFiles {
+		if _, err := source.Stat(name); err != nil {
+			continue
+		}
+
+		file, err := source.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open manifest: %w", err)
+		}
+		defer file.Close()
+
+		manifest, err := parseManifest(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse manifest %s: %w", name, err)
+		}
+		return manifest, nil
+	}
+	return nil, nil
+}
+
+// parseManifest parses the small YAML subset used by manifests:
+// nested "key: value" maps, "- item" lists and inline [a, b] lists
+func parseManifest(reader io.Reader) (*Manifest, error) {
+	manifest := &Manifest{
+		Aliases: make(map[string]string),
+		Globals: make(map[string]any),
+	}
+
+	scanner := bufio.NewScanner(reader)
+	section := ""
+	lineNum := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNum++
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Indented lines belong to the current section
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if section == "" {
+				return nil, fmt.Errorf("line %d: unexpected indentation", lineNum)
+			}
+
+			if item, ok := strings.CutPrefix(trimmed, "- "); ok {
+				list := manifest.list(section)
+				if list == nil {
+					return nil, fmt.Errorf("line %d: %s is not a list", lineNum, section)
+				}
+				*list = append(*list, strings.TrimSpace(item))
+				continue
+			}
+
+			key, value, ok := strings.Cut(trimmed, ":")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key: value", lineNum)
+			}
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+			switch section {
+			case "aliases":
+				manifest.Aliases[key] = value
+			case "globals":
+				manifest.Globals[key] = parseScalar(value)
+			default:
+				return nil, fmt.Errorf("line %d: %s is not a map", lineNum, section)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", lineNum)
+		}
+		section, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch section {
+		case "aliases", "globals":
+			if value != "" {
+				return nil, fmt.Errorf("line %d: %s must be a map", lineNum, section)
+			}
+		case "allowed_roles", "required_metadata":
+			if value != "" {
+				*manifest.list(section) = parseInlineList(value)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown manifest key %q", lineNum, section)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// list returns the list field for a manifest key, or nil if the key is not a list
+func (m *Manifest) list(key string) *[]string {
+	switch key {
+	case "allowed_roles":
+		return &m.AllowedRoles
+	case "required_metadata":
+		return &m.RequiredMetadata
+	}
+	return nil
+}
+
+// parseInlineList parses "[a, b]" or "a, b" into a list
+// Quoted items may contain commas, double quoted ones support escapes like "\n"
+func parseInlineList(value string) []string {
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	var items []string
+	start := 0
+	var quote byte
+	for i := 0; i <= len(value); i++ {
+		if i < len(value) {
+			switch c := value[i]; {
+			case quote != 0 && c == '\\' && quote == '"':
+				i++
+				continue
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+				continue
+			case c == '"' || c == '\'':
+				quote = c
+				continue
+			case c != ',':
+				continue
+			}
+		}
+		if item := strings.TrimSpace(value[start:i]); item != "" {
+			items = append(items, unquoteListItem(item))
+		}
+		start = i + 1
+	}
+	return items
+}
+
+// unquoteListItem removes the quotes of a "double" or 'single' quoted item
+func unquoteListItem(item string) string {
+	if len(item) < 2 || item[0] != item[len(item)-1] {
+		return item
+	}
+	switch item[0] {
+	case '"':
+		if unquoted, err := strconv.Unquote(item); err == nil {
+			return unquoted
+		}
+	case '\'':
+		return item[1 : len(item)-1]
+	}
+	return item
+}
+
+// applyManifest merges manifest settings under the explicit config values
+func applyManifest(config Config, manifest *Manifest) Config {
+	if len(manifest.Aliases) > 0 {
+		aliases := make(map[string]string)
+		for k, v := range manifest.Aliases {
+			aliases[k] = v
+		}
+		for k, v := range config.Aliases {
+			aliases[k] = v
+		}
+		config.Aliases = aliases
+	}
+
+	if len(manifest.Globals) > 0 {
+		globals := make(map[string]any)
+		for k, v := range manifest.Globals {
+			globals[k] = v
+		}
+		for k, v := range config.GlobalVars {
+			globals[k] = v
+		}
+		config.GlobalVars = globals
+	}
+
+	if len(config.AllowedRoles) == 0 {
+		config.AllowedRoles = manifest.AllowedRoles
+	}
+
+	if len(manifest.RequiredMetadata) > 0 {
+		schema := MetadataSchema{}
+		if config.MetadataSchema != nil {
+			schema = *config.MetadataSchema
+		}
+		if len(schema.Required) == 0 {
+			schema.Required = manifest.RequiredMetadata
+		}
+		config.MetadataSchema = &schema
+	}
+
+	return config
+}
@@ -0,0 +1,94 @@
+package echotemplates
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseManifest(t *testing.T) {
+	content := `# library settings
+aliases:
+  greet: greetings/hello
+globals:
+  company: ACME
+  year: 2024
+allowed_roles: [system, user, developer]
+required_metadata:
+  - model
+  - description
+`
+	manifest, err := parseManifest(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := &Manifest{
+		Aliases:          map[string]string{"greet": "greetings/hello"},
+		Globals:          map[string]any{"company": "ACME", "year": 2024},
+		AllowedRoles:     []string{"system", "user", "developer"},
+		RequiredMetadata: []string{"model", "description"},
+	}
+	if !reflect.DeepEqual(manifest, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, manifest)
+	}
+
+	invalid := []string{
+		"unknown: value",
+		"  indented: first",
+		"aliases: inline",
+		"aliases:\n  - item",
+		"allowed_roles:\n  key: value",
+	}
+	for _, content := range invalid {
+		if _, err := parseManifest(strings.NewReader(content)); err == nil {
+			t.Errorf("Expected error for %q", content)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"manifest.yaml":      "aliases:\n  greet: greetings/hello\nglobals:\n  company: ACME\n  product: Echo\nrequired_metadata: model",
+		"greetings/hello.md": "---\nmodel: gpt-4\n---\n@user:\nHello from {{company}} {{product}}",
+		"main.md":            "---\nmodel: gpt-4\n---\n{{@greet}}",
+		"bare.md":            "@user:\nHi",
+	})
+
+	engine, err := New(Config{
+		Source:     source,
+		GlobalVars: map[string]any{"product": "Templates"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	// Aliases work for Generate and imports, config globals win over the manifest
+	for _, name := range []string{"greet", "main"} {
+		messages, err := engine.Generate(name, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", name, err)
+		}
+		expected := "Hello from ACME Templates"
+		if messages[0].Content != expected {
+			t.Errorf("Expected %q, got %q", expected, messages[0].Content)
+		}
+	}
+
+	if !engine.TemplateExists("greet") {
+		t.Error("Expected alias to exist")
+	}
+
+	// Required metadata comes from the manifest
+	var metaErr *MetadataError
+	if err := engine.ValidateTemplate("bare"); !errors.As(err, &metaErr) || metaErr.Key != "model" {
+		t.Errorf("Expected MetadataError for model, got %v", err)
+	}
+
+	// A broken manifest fails engine creation
+	broken := NewMockSource(map[string]string{".echo-templates.yaml": "unknown: key"})
+	if _, err := New(Config{Source: broken}); err == nil {
+		t.Error("Expected error for invalid manifest")
+	}
+}
@@ -0,0 +1,68 @@
+package echotemplates
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mkozhukh/echo"
+)
+
+// Content part types
+const (
+	PartText  = "text"
+	PartImage = "image"
+)
+
+// imageRegex matches {{image:url}} references and ![alt](url) markdown images
+var imageRegex = regexp.MustCompile(`\{\{\s*image:\s*([^}\s]+)\s*\}\}|!\[[^\]]*\]\(([^)\s]+)\)`)
+
+// ContentPart is a piece of a multimodal message, text or an image reference
+type ContentPart struct {
+	Type string
+	Text string
+	URL  string
+}
+
+// MultimodalMessage is a message split into content parts
+// echo.Message holds plain text only, so parts are kept alongside the role
+type MultimodalMessage struct {
+	Role  string
+	Parts []ContentPart
+}
+
+// MultimodalMessages splits the content of messages into text and image parts
+func MultimodalMessages(messages []echo.Message) []MultimodalMessage {
+	result := make([]MultimodalMessage, 0, len(messages))
+	for _, msg := range messages {
+		result = append(result, MultimodalMessage{Role: msg.Role, Parts: ContentParts(msg.Content)})
+	}
+	return result
+}
+
+// ContentParts splits content at image references, text around them is trimmed
+// and blank text parts are dropped
+func ContentParts(content string) []ContentPart {
+	var parts []ContentPart
+	addText := func(text string) {
+		if text = strings.TrimSpace(text); text != "" {
+			parts = append(parts, ContentPart{Type: PartText, Text: text})
+		}
+	}
+
+	pos := 0
+	for _, match := range imageRegex.FindAllStringSubmatchIndex(content, -1) {
+		addText(content[pos:match[0]])
+		pos = match[1]
+
+		url := ""
+		if match[2] != -1 {
+			url = content[match[2]:match[3]]
+		} else {
+			url = content[match[4]:match[5]]
+		}
+		parts = append(parts, ContentPart{Type: PartImage, URL: url})
+	}
+	addText(content[pos:])
+
+	return parts
+}
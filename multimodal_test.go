@@ -0,0 +1,108 @@
+package echotemplates
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestContentParts(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []ContentPart
+	}{
+		{
+			name:     "text only",
+			content:  "Hello",
+			expected: []ContentPart{{Type: PartText, Text: "Hello"}},
+		},
+		{
+			name:    "image placeholder",
+			content: "Describe this:\n{{image:https://example.com/a.png}}\nBe brief.",
+			expected: []ContentPart{
+				{Type: PartText, Text: "Describe this:"},
+				{Type: PartImage, URL: "https://example.com/a.png"},
+				{Type: PartText, Text: "Be brief."},
+			},
+		},
+		{
+			name:    "markdown images",
+			content: "![first](a.png) vs ![](b.png)",
+			expected: []ContentPart{
+				{Type: PartImage, URL: "a.png"},
+				{Type: PartText, Text: "vs"},
+				{Type: PartImage, URL: "b.png"},
+			},
+		},
+		{
+			name:     "empty",
+			content:  "",
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if parts := ContentParts(tt.content); !reflect.DeepEqual(parts, tt.expected) {
+				t.Errorf("ContentParts(%q) = %v, want %v", tt.content, parts, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateMultimodal(t *testing.T) {
+	engine, err := New(Config{Source: NewMockSource(map[string]string{
+		"describe.md": "---\nmodel: gpt-4o\n---\n@system:\nYou describe images.\n\n@user:\nWhat is in {{name}}?\n{{image:https://example.com/cat.png}}\nAlso compare with ![photo]({{photo}})",
+	})})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	messages, metadata, err := engine.GenerateMultimodal("describe", map[string]any{"name": "the picture", "photo": "https://example.com/dog.png"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []MultimodalMessage{
+		{Role: "system", Parts: []ContentPart{{Type: PartText, Text: "You describe images."}}},
+		{Role: "user", Parts: []ContentPart{
+			{Type: PartText, Text: "What is in the picture?"},
+			{Type: PartImage, URL: "https://example.com/cat.png"},
+			{Type: PartText, Text: "Also compare with"},
+			{Type: PartImage, URL: "https://example.com/dog.png"},
+		}},
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Expected %v, got %v", expected, messages)
+	}
+	if metadata["model"] != "gpt-4o" {
+		t.Errorf("Expected model metadata, got %v", metadata)
+	}
+
+	// Image references are not variables
+	vars, err := engine.GetTemplateVariables("describe")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(vars, []string{"name", "photo"}) {
+		t.Errorf("Expected [name photo], got %v", vars)
+	}
+
+	// Other methods treat image references as regular variables
+	_, err = engine.Generate("describe", map[string]any{"name": "the picture", "photo": "x.png"})
+	var varErr *VariableError
+	if !errors.As(err, &varErr) || !slices.Contains(varErr.MissingVars, "image:https://example.com/cat.png") {
+		t.Errorf("Expected a missing variable error for the image reference, got %v", err)
+	}
+
+	// String templates are supported too
+	stringMessages, _, err := GenerateMultimodal("{{image:a.png}} {{q}}", map[string]any{"q": "Why?"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(stringMessages) != 1 || len(stringMessages[0].Parts) != 2 || stringMessages[0].Parts[1].Text != "Why?" {
+		t.Errorf("Unexpected string template messages %v", stringMessages)
+	}
+}
@@ -2,21 +2,61 @@ package echotemplates
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/mkozhukh/echo"
 )
 
+// defaultFrontMatterDelimiter fences front-matter unless Config.FrontMatterDelimiter is set
+const defaultFrontMatterDelimiter = "---"
+
 // parseFrontMatter extracts front-matter from the beginning of a template
 func parseFrontMatter(reader io.Reader) (map[string]any, string, error) {
+	metadata, content, _, err := scanFrontMatter(reader, defaultFrontMatterDelimiter)
+	return metadata, content, err
+}
+
+// scanFrontMatter extracts front-matter fenced by delimiter lines and also reports malformed lines
+// A +++ fence starts a TOML block instead (see scanTOMLFrontMatter)
+// The returned issues are only fatal in strict mode, so they are collected
+// instead of failing the parse
+func scanFrontMatter(reader io.Reader, delimiter string) (map[string]any, string, []*ParseError, error) {
+	if delimiter == "" {
+		delimiter = defaultFrontMatterDelimiter
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	text := string(data)
+	if first, _, _ := strings.Cut(text, "\n"); strings.TrimRight(first, "\r") == tomlFrontMatterDelimiter {
+		metadata, content, issues := scanTOMLFrontMatter(text)
+		return metadata, content, issues, nil
+	}
+
+	var issues []*ParseError
+	seen := make(map[string]int)
 	metadata := make(map[string]any)
 	defaults := make(map[string]string)
 	metadata["defaults"] = defaults
+	variants := make(map[string]map[string]any)
+	fallbacks := make(map[string]string)
+	validators := make(map[string]string)
+	render := make(map[string]string)
+	types := make(map[string]string)
 
-	scanner := bufio.NewScanner(reader)
+	scanner := bufio.NewScanner(strings.NewReader(text))
 	var contentBuilder strings.Builder
 	inFrontMatter := false
+	block := ""
+	variant := ""
 	lineNum := 0
 
 	for scanner.Scan() {
@@ -24,39 +64,110 @@ func parseFrontMatter(reader io.Reader) (map[string]any, string, error) {
 		lineNum++
 
 		// Check if first line is front matter delimiter
-		if lineNum == 1 && line == "---" {
+		if lineNum == 1 && line == delimiter {
 			inFrontMatter = true
 			continue
 		}
 
 		// Check for end of front matter
-		if inFrontMatter && line == "---" {
+		if inFrontMatter && line == delimiter {
 			inFrontMatter = false
 			continue
 		}
 
 		if inFrontMatter {
+			// Indented lines after "defaults:" or "variants:" belong to the nested map
+			isIndented := strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+			if block != "" && isIndented {
+				if issue := checkFrontMatterLine(line, lineNum); issue != nil {
+					issues = append(issues, issue)
+					continue
+				}
+
+				parts := strings.SplitN(line, ":", 2)
+				if len(parts) != 2 {
+					continue
+				}
+				name := strings.TrimSpace(parts[0])
+				value := strings.TrimSpace(parts[1])
+
+				switch block {
+				case "defaults":
+					if issue := checkDuplicateKey(seen, "default."+name, lineNum); issue != nil {
+						issues = append(issues, issue)
+					}
+					// Kept as written like default.<name>, so 007 and 0.50 survive
+					defaults[name] = value
+				case "variants":
+					if value == "" {
+						// Start of a named variant
+						if issue := checkDuplicateKey(seen, "variants."+name, lineNum); issue != nil {
+							issues = append(issues, issue)
+						}
+						variant = name
+						if _, ok := variants[variant]; !ok {
+							variants[variant] = make(map[string]any)
+						}
+					} else if variant != "" {
+						if issue := checkDuplicateKey(seen, "variants."+variant+"."+name, lineNum); issue != nil {
+							issues = append(issues, issue)
+						}
+						variants[variant][name] = parseValue(value)
+					}
+				}
+				continue
+			}
+			block = ""
+
+			if issue := checkFrontMatterLine(line, lineNum); issue != nil {
+				issues = append(issues, issue)
+				continue
+			}
+
 			// Parse front-matter line
 			parts := strings.SplitN(line, ":", 2)
 			if len(parts) == 2 {
 				key := strings.TrimSpace(parts[0])
 				value := strings.TrimSpace(parts[1])
 
-				// Check for default.variable format
-				if strings.HasPrefix(key, "default.") {
+				if issue := checkDuplicateKey(seen, key, lineNum); issue != nil {
+					issues = append(issues, issue)
+				}
+
+				if (key == "defaults" || key == "variants") && value == "" {
+					// Start of nested defaults or variants map
+					block = key
+					variant = ""
+				} else if strings.HasPrefix(key, "default.") {
+					// Check for default.variable format
 					varName := strings.TrimPrefix(key, "default.")
 					defaults[varName] = value
-				} else {
-					// Try to parse as number for regular metadata
-					if num, err := strconv.ParseFloat(value, 64); err == nil {
-						if num == float64(int(num)) {
-							metadata[key] = int(num)
-						} else {
-							metadata[key] = num
-						}
-					} else {
-						metadata[key] = value
+				} else if varName, ok := strings.CutPrefix(key, "fallback."); ok {
+					// Last-resort values, used after inline defaults
+					fallbacks[varName] = value
+				} else if varName, ok := strings.CutPrefix(key, "validate."); ok {
+					// Patterns the variable value must match
+					if issue := addValidator(validators, varName, value, lineNum); issue != nil {
+						issues = append(issues, issue)
+					}
+				} else if varName, ok := strings.CutPrefix(key, "render."); ok {
+					// How list values of the variable are written
+					if issue := addRenderMode(render, varName, value, lineNum); issue != nil {
+						issues = append(issues, issue)
 					}
+				} else if varName, ok := strings.CutPrefix(key, "type."); ok {
+					// JSON type of the variable for VariablesJSONSchema
+					if issue := addTypeHint(types, varName, value, lineNum); issue != nil {
+						issues = append(issues, issue)
+					}
+				} else if key == "version" {
+					// Versions like 1.10 are kept as written
+					metadata[key] = value
+				} else if key == stopMetadataKey {
+					// A single stop sequence is a list of one
+					metadata[key] = parseInlineList(value)
+				} else {
+					metadata[key] = parseValue(value)
 				}
 			}
 		} else {
@@ -69,11 +180,166 @@ func parseFrontMatter(reader io.Reader) (map[string]any, string, error) {
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, "", err
+		return nil, "", nil, err
+	}
+
+	if len(variants) > 0 {
+		metadata["variants"] = variants
+	}
+	if len(fallbacks) > 0 {
+		metadata["fallbacks"] = fallbacks
+	}
+	if len(validators) > 0 {
+		metadata["validate"] = validators
+	}
+	if len(render) > 0 {
+		metadata["render_modes"] = render
+	}
+	if len(types) > 0 {
+		metadata["type_hints"] = types
+	}
+	splitConditionalDefaults(metadata, defaults)
+
+	// A missing closing fence explains any other issues, so report it first
+	if inFrontMatter {
+		issues = append([]*ParseError{{
+			Line:    1,
+			Message: "unterminated front-matter block, missing closing " + delimiter,
+		}}, issues...)
 	}
 
 	content := strings.TrimRight(contentBuilder.String(), "\n")
-	return metadata, content, nil
+	return metadata, content, issues, nil
+}
+
+// conditionalDefaultRegex matches default names like greeting[formal]
+var conditionalDefaultRegex = regexp.MustCompile(`^([\w.-]+)\[\s*([^\]]+?)\s*\]$`)
+
+// defaultSelectorVar selects conditional defaults unless default_selector names another variable
+const defaultSelectorVar = "style"
+
+// splitConditionalDefaults moves defaults like greeting[formal] from the plain
+// defaults into metadata["conditional_defaults"], keyed by variable and selector value
+func splitConditionalDefaults(metadata map[string]any, defaults map[string]string) {
+	conditional := make(map[string]map[string]string)
+	for key, value := range defaults {
+		match := conditionalDefaultRegex.FindStringSubmatch(key)
+		if match == nil {
+			continue
+		}
+		if conditional[match[1]] == nil {
+			conditional[match[1]] = make(map[string]string)
+		}
+		conditional[match[1]][match[2]] = value
+		delete(defaults, key)
+	}
+	if len(conditional) > 0 {
+		metadata["conditional_defaults"] = conditional
+	}
+}
+
+// addValidator records the validate.<name> pattern of a variable
+// An invalid pattern is kept, so generation fails instead of skipping the check
+func addValidator(validators map[string]string, name, pattern string, lineNum int) *ParseError {
+	validators[name] = pattern
+	if _, err := regexp.Compile(pattern); err != nil {
+		return &ParseError{
+			Line:    lineNum,
+			Message: fmt.Sprintf("invalid validate.%s pattern: %v", name, err),
+		}
+	}
+	return nil
+}
+
+// compileValidators compiles the valid validate.<name> patterns of the metadata
+func compileValidators(metadata map[string]any) map[string]*regexp.Regexp {
+	patterns, _ := metadata["validate"].(map[string]string)
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	validators := make(map[string]*regexp.Regexp, len(patterns))
+	for name, pattern := range patterns {
+		if re, err := regexp.Compile(pattern); err == nil {
+			validators[name] = re
+		}
+	}
+	return validators
+}
+
+// addRenderMode records the render.<name> mode of a variable, e.g. list or csv
+func addRenderMode(render map[string]string, name, mode string, lineNum int) *ParseError {
+	mode = strings.TrimSpace(mode)
+	if _, ok := renderModes[mode]; !ok {
+		return &ParseError{
+			Line:    lineNum,
+			Message: fmt.Sprintf("unknown render.%s mode %q, expected csv, lines, list or numbered", name, mode),
+		}
+	}
+	render[name] = mode
+	return nil
+}
+
+// addTypeHint records the type.<name> hint of a variable, e.g. integer or boolean
+func addTypeHint(types map[string]string, name, kind string, lineNum int) *ParseError {
+	kind = strings.ToLower(strings.Trim(strings.TrimSpace(kind), `"'`))
+	if !slices.Contains(jsonSchemaTypes, kind) {
+		return &ParseError{
+			Line:    lineNum,
+			Message: fmt.Sprintf("unknown type.%s %q, expected boolean, integer, number or string", name, kind),
+		}
+	}
+	types[name] = kind
+	return nil
+}
+
+// checkDuplicateKey reports a front-matter key that was already declared
+func checkDuplicateKey(seen map[string]int, key string, lineNum int) *ParseError {
+	if first, ok := seen[key]; ok {
+		return &ParseError{
+			Line:    lineNum,
+			Message: fmt.Sprintf("duplicate front-matter key %q, first declared at line %d", key, first),
+		}
+	}
+	seen[key] = lineNum
+	return nil
+}
+
+// checkFrontMatterLine reports a front-matter line that is not a "key: value" pair
+// Blank lines and # comments are allowed
+func checkFrontMatterLine(line string, lineNum int) *ParseError {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return nil
+	}
+
+	parts := strings.SplitN(trimmed, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return &ParseError{
+			Line:    lineNum,
+			Message: fmt.Sprintf("malformed front-matter line %q, expected key: value", trimmed),
+		}
+	}
+	return nil
+}
+
+// parseValue converts a front-matter value, [a, b] becomes a []string
+func parseValue(value string) any {
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		return parseInlineList(value)
+	}
+	return parseScalar(value)
+}
+
+// parseScalar converts a front-matter value to int or float64 when it is numeric
+func parseScalar(value string) any {
+	if num, err := strconv.ParseFloat(value, 64); err == nil {
+		if num == float64(int(num)) {
+			return int(num)
+		}
+		return num
+	}
+	return value
 }
 
 var (
@@ -81,68 +347,319 @@ var (
 	placeholderRegex    = regexp.MustCompile(`\{\{([^}]+)\}\}`)
 	importRegex         = regexp.MustCompile(`\{\{@(.+?)\}\}`)
 	rawPlaceholderRegex = regexp.MustCompile(`\{\{\{([^}]+)\}\}\}`)
+	blockMarkerRegex    = regexp.MustCompile(`[ \t]*\{\{[#/]block[^}]*\}\}[ \t]*\n?`)
+	regionMarkerRegex   = regexp.MustCompile(`[ \t]*\{\{#(?:end)?region\b[^}]*\}\}[ \t]*\n?`)
+	regionTagRegex      = regexp.MustCompile(`\{\{#(region|endregion)\b([^}]*)\}\}`)
+)
+
+// Escaped braces are swapped for private-use runes while placeholders are
+// processed, so they are never matched as placeholders or imports
+const (
+	escapedOpen  = "\uE000"
+	escapedClose = "\uE001"
+)
+
+// Sanitized variable values hide @ and {{ behind private-use runes until the
+// content is parsed into messages, see GenerateOptions.SanitizeVars
+const (
+	sanitizedAt   = "\uE004"
+	sanitizedOpen = "\uE005"
 )
 
+var (
+	varSanitizer   = strings.NewReplacer("@", sanitizedAt, "{{", sanitizedOpen)
+	varUnsanitizer = strings.NewReplacer(sanitizedAt, "@", sanitizedOpen, "{{")
+)
+
+// sanitizeVar hides role markers and import or placeholder sigils in a value
+// when GenerateOptions.SanitizeVars is set
+func sanitizeVar(value string, opts GenerateOptions) string {
+	if !opts.SanitizeVars {
+		return value
+	}
+	return varSanitizer.Replace(value)
+}
+
+var (
+	braceEscaper   = strings.NewReplacer(`\{{`, escapedOpen, `\}}`, escapedClose)
+	braceUnescaper = strings.NewReplacer(escapedOpen, "{{", escapedClose, "}}")
+)
+
+// escapeBraces hides \{{ and \}} sequences from placeholder processing
+func escapeBraces(content string) string {
+	return braceEscaper.Replace(content)
+}
+
+// unescapeBraces turns hidden escape sequences into literal braces
+func unescapeBraces(content string) string {
+	return braceUnescaper.Replace(content)
+}
+
+// nowPrefix marks placeholders that insert the current time
+const nowPrefix = "now:"
+
+// imagePrefix marks {{image:url}} references, rendered by GenerateMultimodal
+const imagePrefix = "image:"
+
+// formatNow formats the current time using the given Go layout (RFC 3339 if empty)
+func formatNow(layout string, opts GenerateOptions) string {
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+
+	layout = strings.TrimSpace(layout)
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	return now().Format(layout)
+}
+
 // parsedTemplate represents a template after initial parsing
 type parsedTemplate struct {
 	metadata map[string]any
 	content  string
 	imports  []string
+	issues   []*ParseError
+
+	// lineOffset is the number of front-matter lines before the content
+	lineOffset int
+
+	// validators are the compiled validate.<name> patterns of the metadata
+	validators map[string]*regexp.Regexp
+}
+
+// frontMatterLines returns the number of lines taken by the front-matter block
+func frontMatterLines(text, delimiter string) int {
+	if delimiter == "" {
+		delimiter = defaultFrontMatterDelimiter
+	}
+
+	lines := strings.Split(text, "\n")
+	if first := strings.TrimRight(lines[0], "\r"); first == tomlFrontMatterDelimiter {
+		delimiter = first
+	} else if first != delimiter {
+		return 0
+	}
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimRight(lines[i], "\r") == delimiter {
+			return i + 1
+		}
+	}
+	return len(lines)
+}
+
+// braceOpener is an unclosed {{ or {{{ found by checkBraces
+type braceOpener struct {
+	size int
+	line int
+}
+
+// checkBraces reports the first {{ or {{{ that is not closed by a matching }} or }}}
+// Stray closing braces are ignored, as they are common in JSON examples
+func checkBraces(content string) *ParseError {
+	content = escapeBraces(content)
+
+	var stack []braceOpener
+	line := 1
+	for i := 0; i < len(content); {
+		switch {
+		case content[i] == '\n':
+			line++
+			i++
+		case strings.HasPrefix(content[i:], "{{{"):
+			stack = append(stack, braceOpener{size: 3, line: line})
+			i += 3
+		case strings.HasPrefix(content[i:], "{{"):
+			stack = append(stack, braceOpener{size: 2, line: line})
+			i += 2
+		case len(stack) > 0 && stack[len(stack)-1].size == 3 && strings.HasPrefix(content[i:], "}}}"):
+			stack = stack[:len(stack)-1]
+			i += 3
+		case strings.HasPrefix(content[i:], "}}"):
+			if len(stack) > 0 {
+				top := stack[len(stack)-1]
+				if top.size == 3 {
+					return &ParseError{Line: top.line, Message: "{{{ is closed by }} instead of }}}"}
+				}
+				stack = stack[:len(stack)-1]
+			}
+			i += 2
+		default:
+			i++
+		}
+	}
+
+	if len(stack) > 0 {
+		opener := stack[0]
+		return &ParseError{Line: opener.line, Message: fmt.Sprintf("unclosed %s", strings.Repeat("{", opener.size))}
+	}
+	return nil
 }
 
 // substituteVariables replaces placeholders with actual values
-func substituteVariables(content string, vars map[string]string, defaults map[string]string, opts GenerateOptions) (string, error) {
-	// First handle triple-brace raw placeholders
-	content = rawPlaceholderRegex.ReplaceAllStringFunc(content, func(match string) string {
-		varName := strings.TrimSpace(match[3 : len(match)-3])
-		if value, ok := vars[varName]; ok {
-			return value
+// raw holds the original values for filters that need their structure (e.g. join)
+// The content is scanned once; raw, import and regular placeholders are handled together
+func substituteVariables(content string, vars map[string]string, raw map[string]any, opts GenerateOptions) (string, error) {
+	// Hide escaped braces so they are not treated as placeholders
+	escaped := hasEscapes(content)
+	if escaped {
+		content = escapeBraces(content)
+	}
+
+	// Nothing to do without placeholders
+	if !strings.Contains(content, "{{") {
+		if escaped {
+			content = unescapeBraces(content)
 		}
-		return match // Keep original if not found
-	})
+		return content, nil
+	}
+
+	var b strings.Builder
+	b.Grow(len(content))
 
-	// Handle regular placeholders
 	var missingVars []string
+	for i := 0; i < len(content); {
+		next := strings.Index(content[i:], "{{")
+		if next == -1 {
+			b.WriteString(content[i:])
+			break
+		}
+		b.WriteString(content[i : i+next])
+		i += next
+
+		// Triple-brace raw placeholders are inserted as is
+		if name, end, ok := scanPlaceholder(content, i, 3); ok {
+			if value, ok := lookupVar(strings.TrimSpace(name), vars, opts); ok {
+				b.WriteString(sanitizeVar(value, opts))
+				i = end
+				continue
+			}
+		}
+
+		inner, end, ok := scanPlaceholder(content, i, 2)
+		if !ok {
+			b.WriteByte(content[i])
+			i++
+			continue
+		}
+		match := content[i:end]
+		i = end
 
-	content = placeholderRegex.ReplaceAllStringFunc(content, func(match string) string {
 		// Skip import placeholders
-		if strings.HasPrefix(match, "{{@") {
-			return match
+		if inner[0] == '@' {
+			b.WriteString(match)
+			continue
 		}
 
-		inner := strings.TrimSpace(match[2 : len(match)-2])
+		inner = strings.TrimSpace(inner)
+
+		// Current time formatted with a Go layout, e.g. {{now:2006-01-02}}
+		if layout, ok := strings.CutPrefix(inner, nowPrefix); ok {
+			b.WriteString(formatNow(layout, opts))
+			continue
+		}
 
-		// Check for default value syntax
-		parts := strings.SplitN(inner, "|", 2)
-		varName := strings.TrimSpace(parts[0])
-		defaultValue := ""
-		if len(parts) > 1 {
-			defaultValue = strings.TrimSpace(parts[1])
+		// Image references are only kept for GenerateMultimodal
+		if opts.images && strings.HasPrefix(inner, imagePrefix) {
+			b.WriteString(match)
+			continue
+		}
+
+		// Check for default value and filter syntax
+		varName, defaultValue, filters := parsePlaceholder(inner)
+		if opts.StrictFilters && defaultValue != "" {
+			if err := checkUnknownFilter(inner, defaultValue); err != nil {
+				return "", err
+			}
 		}
 
-		// Try to get value from vars, then defaults, then use default value
-		if value, ok := vars[varName]; ok {
-			return value
+		// Try to get value from vars, then the inline default, then the front-matter fallback
+		if value, ok := lookupVar(varName, vars, opts); ok {
+			value = renderValue(value, raw[varName], opts.render[varName])
+			value = applyFilters(value, raw[varName], filters)
+			b.WriteString(sanitizeVar(transformVar(varName, value, opts), opts))
+			continue
 		}
 		if defaultValue != "" {
-			return defaultValue
+			value := applyFilters(defaultValue, nil, filters)
+			b.WriteString(transformVar(varName, value, opts))
+			continue
+		}
+		if value, ok := opts.fallbacks[varName]; ok {
+			value = applyFilters(value, nil, filters)
+			b.WriteString(transformVar(varName, value, opts))
+			continue
 		}
 
 		// Variable not found
-		if !opts.AllowMissingVars {
-			missingVars = append(missingVars, varName)
+		if !opts.AllowMissingVars && !slices.Contains(missingVars, varName) {
+			if !opts.FailOnFirstMissing || len(missingVars) == 0 {
+				missingVars = append(missingVars, varName)
+			}
 		}
-		return match // Keep original placeholder
-	})
+		b.WriteString(match) // Keep original placeholder
+	}
 
 	if len(missingVars) > 0 && !opts.AllowMissingVars {
 		return "", &VariableError{
-			Variable: strings.Join(missingVars, ", "),
-			Template: "current",
+			Variable:    strings.Join(missingVars, ", "),
+			Template:    "current",
+			MissingVars: missingVars,
 		}
 	}
 
-	return content, nil
+	result := b.String()
+	if escaped {
+		result = unescapeBraces(result)
+	}
+	return result, nil
+}
+
+// scanPlaceholder matches a placeholder with the given number of braces at position i,
+// e.g. {{name}} or {{{name}}}, and returns its non-empty inner text and end position
+func scanPlaceholder(content string, i, braces int) (string, int, bool) {
+	start := i + braces
+	if start > len(content) || strings.Count(content[i:start], "{") != braces {
+		return "", 0, false
+	}
+
+	stop := strings.IndexByte(content[start:], '}')
+	if stop <= 0 {
+		return "", 0, false
+	}
+	stop += start
+
+	end := stop + braces
+	if end > len(content) || strings.Count(content[stop:end], "}") != braces {
+		return "", 0, false
+	}
+	return content[start:stop], end, true
+}
+
+// hasEscapes reports whether content may contain escaped braces, either
+// as \{{ and \}} or already hidden by escapeBraces
+func hasEscapes(content string) bool {
+	return strings.Contains(content, `\`) || strings.ContainsAny(content, escapedOpen+escapedClose)
+}
+
+// lookupVar finds a variable value, consulting the resolver before vars
+func lookupVar(name string, vars map[string]string, opts GenerateOptions) (string, bool) {
+	if opts.resolver != nil {
+		if value, ok := opts.resolver.Resolve(name); ok {
+			return value, true
+		}
+	}
+	value, ok := vars[name]
+	return value, ok
+}
+
+// transformVar applies the VarTransform hook to a resolved value
+func transformVar(name, value string, opts GenerateOptions) string {
+	if opts.VarTransform == nil {
+		return value
+	}
+	return opts.VarTransform(name, value)
 }
 
 // extractImports finds all import placeholders in content
@@ -157,6 +674,12 @@ func extractImports(content string) []string {
 		}
 		idx += start
 
+		// Skip escaped import markers
+		if idx > 0 && content[idx-1] == '\\' {
+			start = idx + 3
+			continue
+		}
+
 		// Find the closing }}
 		end := idx + 3
 		braceCount := 1
@@ -182,3 +705,312 @@ func extractImports(content string) []string {
 	}
 	return imports
 }
+
+// splitImportAlternatives splits an import expression on top-level "|"
+// so that "{{@a/{{x}}|a/default}}" yields the primary path and its fallbacks
+func splitImportAlternatives(expr string) []string {
+	var parts []string
+	depth := 0
+	last := 0
+	for i := 0; i < len(expr); i++ {
+		switch {
+		case strings.HasPrefix(expr[i:], "{{"):
+			depth++
+			i++
+		case strings.HasPrefix(expr[i:], "}}"):
+			depth--
+			i++
+		case expr[i] == '|' && depth == 0:
+			parts = append(parts, strings.TrimSpace(expr[last:i]))
+			last = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(expr[last:]))
+}
+
+// splitImportFragment splits "path#section" into the path and the section name
+func splitImportFragment(importPath string) (string, string) {
+	idx := strings.LastIndex(importPath, "#")
+	if idx == -1 {
+		return importPath, ""
+	}
+	return strings.TrimSpace(importPath[:idx]), strings.TrimSpace(importPath[idx+1:])
+}
+
+// conversationDelimiter is the line separating conversations, see GenerateConversations
+const conversationDelimiter = "@@@"
+
+// splitConversations splits content on @@@ lines, dropping blank conversations
+func splitConversations(content string) []string {
+	var conversations []string
+	var current []string
+	flush := func() {
+		if chunk := strings.Trim(strings.Join(current, "\n"), "\n"); strings.TrimSpace(chunk) != "" {
+			conversations = append(conversations, chunk)
+		}
+		current = nil
+	}
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == conversationDelimiter {
+			flush()
+			continue
+		}
+		current = append(current, line)
+	}
+	flush()
+	return conversations
+}
+
+// blockOpenRegex matches {{#block name}} markers, capturing the name
+var blockOpenRegex = regexp.MustCompile(`\{\{#block\s+([^}]*?)\s*\}\}`)
+
+// blockCloseMarker ends the body of a named block
+const blockCloseMarker = "{{/block}}"
+
+// findBlock locates the first block with the given name, returning the indexes of the
+// whole block and of its body as in FindStringSubmatchIndex, or nil if it isn't found
+func findBlock(content, name string) []int {
+	for _, open := range blockOpenRegex.FindAllStringSubmatchIndex(content, -1) {
+		if content[open[2]:open[3]] != name {
+			continue
+		}
+		end := strings.Index(content[open[1]:], blockCloseMarker)
+		if end == -1 {
+			continue
+		}
+		bodyEnd := open[1] + end
+		return []int{open[0], bodyEnd + len(blockCloseMarker), open[1], bodyEnd}
+	}
+	return nil
+}
+
+// extractBlock returns the content of the named block and the role of the section
+// it is in, the role is empty when the block comes before any role marker
+func extractBlock(content, name string) (string, string, bool) {
+	loc := findBlock(content, name)
+	if loc == nil {
+		return "", "", false
+	}
+
+	role := ""
+	for _, line := range strings.Split(content[:loc[0]], "\n") {
+		if r, _, ok := parseRoleMarker(line); ok {
+			role = r
+		}
+	}
+	return strings.Trim(content[loc[2]:loc[3]], "\n"), role, true
+}
+
+// extractSection returns the named block or the body of the role section
+// with the given name, e.g. "system" for the content after @system:
+func extractSection(content, name string) (string, bool) {
+	// Named blocks take precedence over role sections
+	if loc := findBlock(content, name); loc != nil {
+		return strings.Trim(content[loc[2]:loc[3]], "\n"), true
+	}
+
+	var section []string
+	found := false
+	inSection := false
+	for _, line := range strings.Split(content, "\n") {
+		if role, rest, ok := parseRoleMarker(line); ok {
+			if inSection {
+				break
+			}
+			if role == name {
+				inSection = true
+				found = true
+				if rest != "" {
+					section = append(section, rest)
+				}
+			}
+			continue
+		}
+		if inSection {
+			section = append(section, line)
+		}
+	}
+
+	return strings.TrimSpace(strings.Join(section, "\n")), found
+}
+
+// parseRoleMarker detects "@role:" lines the same way echo.TemplateMessage does
+func parseRoleMarker(line string) (string, string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "@") || !strings.Contains(trimmed, ":") {
+		return "", "", false
+	}
+	parts := strings.SplitN(trimmed, ":", 2)
+	return strings.TrimSpace(strings.TrimPrefix(parts[0], "@")), strings.TrimSpace(parts[1]), true
+}
+
+// builtinRoles are the roles recognized by echo.TemplateMessage
+var builtinRoles = []string{echo.System, echo.User, echo.Agent}
+
+// normalizeRole lowercases built-in roles written in another case, e.g. System
+func normalizeRole(role string) string {
+	if lower := strings.ToLower(role); lower != role && slices.Contains(builtinRoles, lower) {
+		return lower
+	}
+	return role
+}
+
+// parseMessages splits content into messages like echo.TemplateMessage
+// With allowed roles, exactly those are accepted and keep their casing, and sections
+// with other roles are skipped, or reported in strict mode with the line of the rendered content;
+// otherwise only built-in roles are recognized in any case, e.g. @System:, and other sections are skipped
+// Markers without a role (@:) are reported in strict mode either way
+func parseMessages(content, template string, allowed []string, strict bool) ([]echo.Message, error) {
+	var messages []echo.Message
+	var role string
+	var lines []string
+
+	flush := func() {
+		if role == "" {
+			return
+		}
+		text := strings.TrimSpace(strings.Join(lines, "\n"))
+		if text != "" {
+			messages = append(messages, echo.Message{Role: role, Content: text})
+		}
+	}
+
+	for i, line := range strings.Split(content, "\n") {
+		marker, rest, ok := parseRoleMarker(line)
+		if !ok {
+			if role != "" {
+				lines = append(lines, line)
+			}
+			continue
+		}
+
+		flush()
+		role, lines = marker, nil
+		message := ""
+		switch {
+		case role == "":
+			message = fmt.Sprintf("empty role in marker %q", strings.TrimSpace(line))
+		case len(allowed) == 0:
+			role = normalizeRole(role)
+			if !slices.Contains(builtinRoles, role) {
+				role = ""
+				continue
+			}
+		case !slices.Contains(allowed, role):
+			message = fmt.Sprintf("role %q is not allowed", role)
+		}
+		if message != "" {
+			if strict {
+				return nil, &ParseError{Template: template, Line: i + 1, Message: message}
+			}
+			role = ""
+			continue
+		}
+		if rest != "" {
+			lines = append(lines, rest)
+		}
+	}
+	flush()
+
+	return messages, nil
+}
+
+// stripHTMLComments removes <!-- ... --> comments from the content
+// Lines holding only a comment are removed entirely, and text inside
+// raw {{{ }}} placeholders is left untouched
+func stripHTMLComments(content string) string {
+	if !strings.Contains(content, "<!--") {
+		return content
+	}
+
+	var b strings.Builder
+	b.Grow(len(content))
+	atLineStart := true
+	write := func(text string) {
+		if text != "" {
+			b.WriteString(text)
+			atLineStart = strings.HasSuffix(text, "\n")
+		}
+	}
+
+	for {
+		start := strings.Index(content, "<!--")
+		if start == -1 {
+			break
+		}
+
+		// Copy raw placeholders that begin before the comment as is
+		if raw := strings.Index(content[:start], "{{{"); raw != -1 {
+			end := strings.Index(content[raw:], "}}}")
+			if end != -1 {
+				end += raw + 3
+				write(content[:end])
+				content = content[end:]
+				continue
+			}
+		}
+
+		end := strings.Index(content[start+4:], "-->")
+		if end == -1 {
+			break
+		}
+		end += start + 4 + 3
+
+		before, after := content[:start], content[end:]
+
+		// Drop the whole line if it holds nothing but the comment
+		lineStart := strings.LastIndexByte(before, '\n') + 1
+		rest := strings.TrimLeft(after, " \t")
+		onlyComment := strings.TrimSpace(before[lineStart:]) == "" && (lineStart > 0 || atLineStart)
+		if onlyComment && (rest == "" || rest[0] == '\n') {
+			before = before[:lineStart]
+			after = strings.TrimPrefix(rest, "\n")
+		}
+
+		write(before)
+		content = after
+	}
+
+	write(content)
+	return b.String()
+}
+
+// stripBlockMarkers removes named block and {{#region}} markers from the content
+func stripBlockMarkers(content string) string {
+	content = blockMarkerRegex.ReplaceAllString(content, "")
+	return regionMarkerRegex.ReplaceAllString(content, "")
+}
+
+// scanRegions finds {{#region Name}}...{{#endregion}} spans, skipping the first
+// offset lines; nested regions are reported in order of their opening marker
+func scanRegions(text string, offset int, name string) ([]Region, error) {
+	var regions []Region
+	var open []int
+	for i, line := range strings.Split(text, "\n") {
+		if i < offset {
+			continue
+		}
+		for _, match := range regionTagRegex.FindAllStringSubmatch(line, -1) {
+			if match[1] == "region" {
+				regions = append(regions, Region{Name: strings.TrimSpace(match[2]), StartLine: i + 1})
+				open = append(open, len(regions)-1)
+				continue
+			}
+			if len(open) == 0 {
+				return nil, &ParseError{Template: name, Line: i + 1, Message: "{{#endregion}} without an open region"}
+			}
+			regions[open[len(open)-1]].EndLine = i + 1
+			open = open[:len(open)-1]
+		}
+	}
+
+	if len(open) > 0 {
+		region := regions[open[len(open)-1]]
+		return nil, &ParseError{
+			Template: name,
+			Line:     region.StartLine,
+			Message:  fmt.Sprintf("region %q is never closed", region.Name),
+		}
+	}
+	return regions, nil
+}
@@ -1,9 +1,12 @@
 package echotemplates
 
 import (
+	"errors"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseFrontMatter(t *testing.T) {
@@ -79,6 +82,59 @@ Content`,
 			},
 			expectedContent: `Content`,
 		},
+		{
+			name: "nested defaults map",
+			input: `---
+model: gpt-4
+default.role: helpful
+defaults:
+  style: professional
+  retries: 3
+  ratio: 0.50
+  code: 007
+default.tone: friendly
+---
+Content`,
+			expectedMeta: map[string]any{
+				"model": "gpt-4",
+				"defaults": map[string]string{
+					"role":    "helpful",
+					"style":   "professional",
+					"retries": "3",
+					"ratio":   "0.50",
+					"code":    "007",
+					"tone":    "friendly",
+				},
+			},
+			expectedContent: `Content`,
+		},
+		{
+			name: "variants map",
+			input: `---
+model: gpt-4
+variants:
+  fast:
+    model: gpt-4o-mini
+    temperature: 0.2
+  accurate:
+    temperature: 0
+---
+Content`,
+			expectedMeta: map[string]any{
+				"model":    "gpt-4",
+				"defaults": map[string]string{},
+				"variants": map[string]map[string]any{
+					"fast": {
+						"model":       "gpt-4o-mini",
+						"temperature": 0.2,
+					},
+					"accurate": {
+						"temperature": 0,
+					},
+				},
+			},
+			expectedContent: `Content`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -103,83 +159,132 @@ Content`,
 	}
 }
 
-func TestSubstituteVariables(t *testing.T) {
-	tests := []struct {
-		name        string
-		content     string
-		vars        map[string]string
-		defaults    map[string]string
-		opts        GenerateOptions
-		expected    string
-		expectError bool
-	}{
-		{
-			name:    "simple substitution",
-			content: "Hello {{name}}, welcome to {{place}}!",
-			vars: map[string]string{
-				"name":  "Alice",
-				"place": "Wonderland",
-			},
-			expected: "Hello Alice, welcome to Wonderland!",
+// substituteTests are shared by the substitution and parity tests
+var substituteTests = []struct {
+	name        string
+	content     string
+	vars        map[string]string
+	defaults    map[string]string
+	opts        GenerateOptions
+	expected    string
+	expectError bool
+}{
+	{
+		name:    "simple substitution",
+		content: "Hello {{name}}, welcome to {{place}}!",
+		vars: map[string]string{
+			"name":  "Alice",
+			"place": "Wonderland",
 		},
-		{
-			name:    "with defaults",
-			content: "Hello {{name|World}}, you are {{role|guest}}!",
-			vars: map[string]string{
-				"name": "Bob",
-			},
-			expected: "Hello Bob, you are guest!",
+		expected: "Hello Alice, welcome to Wonderland!",
+	},
+	{
+		name:    "with defaults",
+		content: "Hello {{name|World}}, you are {{role|guest}}!",
+		vars: map[string]string{
+			"name": "Bob",
 		},
-		{
-			name:    "raw placeholders",
-			content: "Code: {{{code}}} and {{formatted}}",
-			vars: map[string]string{
-				"code":      "<script>alert('hi')</script>",
-				"formatted": "<b>bold</b>",
-			},
-			expected: "Code: <script>alert('hi')</script> and <b>bold</b>",
+		expected: "Hello Bob, you are guest!",
+	},
+	{
+		name:    "raw placeholders",
+		content: "Code: {{{code}}} and {{formatted}}",
+		vars: map[string]string{
+			"code":      "<script>alert('hi')</script>",
+			"formatted": "<b>bold</b>",
 		},
-		{
-			name:    "missing variable error",
-			content: "Hello {{name}}!",
-			vars:    map[string]string{},
-			opts: GenerateOptions{
-				AllowMissingVars: false,
-			},
-			expectError: true,
+		expected: "Code: <script>alert('hi')</script> and <b>bold</b>",
+	},
+	{
+		name:    "missing variable error",
+		content: "Hello {{name}}!",
+		vars:    map[string]string{},
+		opts: GenerateOptions{
+			AllowMissingVars: false,
 		},
-		{
-			name:    "missing variable allowed",
-			content: "Hello {{name}}!",
-			vars:    map[string]string{},
-			opts: GenerateOptions{
-				AllowMissingVars: true,
-			},
-			expected: "Hello {{name}}!",
+		expectError: true,
+	},
+	{
+		name:    "missing variable allowed",
+		content: "Hello {{name}}!",
+		vars:    map[string]string{},
+		opts: GenerateOptions{
+			AllowMissingVars: true,
 		},
-		{
-			name:    "preserve import placeholders",
-			content: "{{@common/header}} Hello {{name}}!",
-			vars: map[string]string{
-				"name": "Charlie",
-			},
-			expected: "{{@common/header}} Hello Charlie!",
+		expected: "Hello {{name}}!",
+	},
+	{
+		name:    "preserve import placeholders",
+		content: "{{@common/header}} Hello {{name}}!",
+		vars: map[string]string{
+			"name": "Charlie",
 		},
-		{
-			name:    "use defaults from metadata",
-			content: "Style: {{style}}, Tone: {{tone}}",
-			vars: map[string]string{
-				"style": "modern",
+		expected: "{{@common/header}} Hello Charlie!",
+	},
+	{
+		name:    "use defaults from metadata",
+		content: "Style: {{style}}, Tone: {{tone}}",
+		vars: map[string]string{
+			"style": "modern",
+		},
+		defaults: map[string]string{
+			"style": "classic",
+			"tone":  "formal",
+		},
+		expected: "Style: modern, Tone: formal",
+	},
+	{
+		name:    "escaped braces",
+		content: `Use \{{name}} to insert {{name}}, close with \}}`,
+		vars: map[string]string{
+			"name": "Dana",
+		},
+		expected: "Use {{name}} to insert Dana, close with }}",
+	},
+	{
+		name:    "escaped braces next to raw placeholder",
+		content: `\{{{code}}} and {{{code}}}`,
+		vars: map[string]string{
+			"code": "x := 1",
+		},
+		expected: "{{{code}}} and x := 1",
+	},
+	{
+		name:    "time placeholder",
+		content: "Today is {{now:2006-01-02}} at {{ now:15:04 }}, {{name}}",
+		vars: map[string]string{
+			"name": "Ann",
+		},
+		opts: GenerateOptions{
+			Now: func() time.Time {
+				return time.Date(2025, 3, 14, 9, 26, 0, 0, time.UTC)
 			},
-			defaults: map[string]string{
-				"style": "classic",
-				"tone":  "formal",
+		},
+		expected: "Today is 2025-03-14 at 09:26, Ann",
+	},
+	{
+		name:    "variable transform",
+		content: "{{name}} likes {{food|pizza}}, raw: {{{name}}}",
+		vars: map[string]string{
+			"name": "Ann",
+		},
+		opts: GenerateOptions{
+			VarTransform: func(name, value string) string {
+				return strings.ToUpper(value)
 			},
-			expected: "Style: modern, Tone: formal",
 		},
-	}
+		expected: "ANN likes PIZZA, raw: Ann",
+	},
+	{
+		name:     "escaped import",
+		content:  `\{{@common/header}}`,
+		vars:     map[string]string{},
+		expected: "{{@common/header}}",
+	},
+}
 
-	for _, tt := range tests {
+func TestSubstituteVariables(t *testing.T) {
+	for _, tt := range substituteTests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Merge defaults into vars to match the new behavior
 			mergedVars := make(map[string]string)
@@ -245,6 +350,11 @@ You are a {{role}} assistant.
 {{@prompts/{{domain}}/system-prompt}}`,
 			expected: []string{"common/personality", "prompts/{{domain}}/system-prompt"},
 		},
+		{
+			name:     "escaped import",
+			content:  `\{{@header}} {{@footer}}`,
+			expected: []string{"footer"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -257,3 +367,466 @@ You are a {{role}} assistant.
 		})
 	}
 }
+
+func TestSplitImportAlternatives(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected []string
+	}{
+		{"common/header", []string{"common/header"}},
+		{"styles/{{style}}|styles/default", []string{"styles/{{style}}", "styles/default"}},
+		{"styles/{{style|formal}} | styles/default", []string{"styles/{{style|formal}}", "styles/default"}},
+	}
+
+	for _, tt := range tests {
+		got := splitImportAlternatives(tt.expr)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("splitImportAlternatives(%q): expected %v, got %v", tt.expr, tt.expected, got)
+		}
+	}
+}
+
+func TestFrontMatterIssues(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		expectedLine int
+	}{
+		{
+			name: "valid front-matter",
+			input: `---
+model: gpt-4
+# comment
+
+default.role: helpful
+---
+Content`,
+			expectedLine: 0,
+		},
+		{
+			name: "bad key line",
+			input: `---
+model: gpt-4
+temperature 0.7
+---
+Content`,
+			expectedLine: 3,
+		},
+		{
+			name: "duplicate key",
+			input: `---
+model: gpt-4
+temperature: 0.7
+model: claude
+---
+Content`,
+			expectedLine: 4,
+		},
+		{
+			name: "duplicate default key",
+			input: `---
+default.role: helpful
+defaults:
+  style: formal
+  role: strict
+---
+Content`,
+			expectedLine: 5,
+		},
+		{
+			name: "unterminated fence",
+			input: `---
+model: gpt-4
+Content`,
+			expectedLine: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, issues, err := scanFrontMatter(strings.NewReader(tt.input), "")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if tt.expectedLine == 0 {
+				if len(issues) != 0 {
+					t.Errorf("Expected no issues, got %v", issues)
+				}
+				return
+			}
+
+			if len(issues) == 0 {
+				t.Fatal("Expected parse issue")
+			}
+			if issues[0].Line != tt.expectedLine {
+				t.Errorf("Expected issue at line %d, got %d", tt.expectedLine, issues[0].Line)
+			}
+		})
+	}
+}
+
+func TestExtractSection(t *testing.T) {
+	content := `@system:
+System text
+{{#block extra}}
+Extra text
+{{/block}}
+@user: Inline user text
+More user text`
+
+	tests := []struct {
+		name     string
+		expected string
+		found    bool
+	}{
+		{"system", "System text\n{{#block extra}}\nExtra text\n{{/block}}", true},
+		{"user", "Inline user text\nMore user text", true},
+		{"extra", "Extra text", true},
+		{"agent", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := extractSection(content, tt.name)
+		if ok != tt.found || got != tt.expected {
+			t.Errorf("extractSection(%q): expected %q (%v), got %q (%v)", tt.name, tt.expected, tt.found, got, ok)
+		}
+	}
+
+	// Block names are compared exactly, not as patterns or prefixes
+	blocks := "{{#block a.b}}Dotted{{/block}}\n{{#block ab }}Plain{{/block}}"
+	for name, expected := range map[string]string{"a.b": "Dotted", "ab": "Plain", "a": "", "a.*": ""} {
+		if got, _ := extractSection(blocks, name); got != expected {
+			t.Errorf("extractSection(%q): expected %q, got %q", name, expected, got)
+		}
+	}
+}
+
+func TestPlaceholderFilters(t *testing.T) {
+	tests := []struct {
+		name     string
+		inner    string
+		varName  string
+		defValue string
+		filters  []filterCall
+	}{
+		{"plain", "tags", "tags", "", nil},
+		{"default", "role|helpful", "role", "helpful", nil},
+		{"default with pipe", "role|a|b", "role", "a|b", nil},
+		{"join", "tags|join:\\n", "tags", "", []filterCall{{"join", "\n"}}},
+		{"quoted join", `tags | none | join:", "`, "tags", "none", []filterCall{{"join", ", "}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			varName, defValue, filters := parsePlaceholder(tt.inner)
+			if varName != tt.varName || defValue != tt.defValue || !reflect.DeepEqual(filters, tt.filters) {
+				t.Errorf("parsePlaceholder(%q) = %q, %q, %v", tt.inner, varName, defValue, filters)
+			}
+		})
+	}
+}
+
+func TestMissingVariables(t *testing.T) {
+	content := "{{b}} {{a}} {{known}} {{b}} {{c}}"
+	vars := map[string]string{"known": "yes"}
+
+	_, err := substituteVariables(content, vars, nil, GenerateOptions{})
+	varErr, ok := err.(*VariableError)
+	if !ok {
+		t.Fatalf("Expected VariableError, got %v", err)
+	}
+	if !reflect.DeepEqual(varErr.MissingVars, []string{"b", "a", "c"}) {
+		t.Errorf("Expected missing [b a c], got %v", varErr.MissingVars)
+	}
+	if varErr.Variable != "b, a, c" {
+		t.Errorf("Expected joined variable names, got %q", varErr.Variable)
+	}
+
+	_, err = substituteVariables(content, vars, nil, GenerateOptions{FailOnFirstMissing: true})
+	varErr, ok = err.(*VariableError)
+	if !ok {
+		t.Fatalf("Expected VariableError, got %v", err)
+	}
+	if !reflect.DeepEqual(varErr.MissingVars, []string{"b"}) {
+		t.Errorf("Expected missing [b], got %v", varErr.MissingVars)
+	}
+}
+
+// substituteVariablesRegex is the previous regex-based implementation,
+// kept as a reference for parity tests and benchmarks
+func substituteVariablesRegex(content string, vars map[string]string, raw map[string]any, opts GenerateOptions) (string, error) {
+	content = escapeBraces(content)
+
+	content = rawPlaceholderRegex.ReplaceAllStringFunc(content, func(match string) string {
+		varName := strings.TrimSpace(match[3 : len(match)-3])
+		if value, ok := lookupVar(varName, vars, opts); ok {
+			return value
+		}
+		return match
+	})
+
+	var missingVars []string
+	content = placeholderRegex.ReplaceAllStringFunc(content, func(match string) string {
+		if strings.HasPrefix(match, "{{@") {
+			return match
+		}
+
+		inner := strings.TrimSpace(match[2 : len(match)-2])
+		if layout, ok := strings.CutPrefix(inner, nowPrefix); ok {
+			return formatNow(layout, opts)
+		}
+
+		varName, defaultValue, filters := parsePlaceholder(inner)
+		if value, ok := lookupVar(varName, vars, opts); ok {
+			value = applyFilters(value, raw[varName], filters)
+			return transformVar(varName, value, opts)
+		}
+		if defaultValue != "" {
+			value := applyFilters(defaultValue, nil, filters)
+			return transformVar(varName, value, opts)
+		}
+
+		if !opts.AllowMissingVars && !slices.Contains(missingVars, varName) {
+			if !opts.FailOnFirstMissing || len(missingVars) == 0 {
+				missingVars = append(missingVars, varName)
+			}
+		}
+		return match
+	})
+
+	if len(missingVars) > 0 && !opts.AllowMissingVars {
+		return "", &VariableError{
+			Variable:    strings.Join(missingVars, ", "),
+			Template:    "current",
+			MissingVars: missingVars,
+		}
+	}
+
+	return unescapeBraces(content), nil
+}
+
+func TestSubstituteVariablesParity(t *testing.T) {
+	type parityCase struct {
+		name    string
+		content string
+		vars    map[string]string
+		opts    GenerateOptions
+	}
+
+	var cases []parityCase
+	for _, tt := range substituteTests {
+		vars := make(map[string]string)
+		for k, v := range tt.defaults {
+			vars[k] = v
+		}
+		for k, v := range tt.vars {
+			vars[k] = v
+		}
+		cases = append(cases, parityCase{tt.name, tt.content, vars, tt.opts})
+	}
+
+	vars := map[string]string{"a": "A", "b": "B", "tags": "x,y"}
+	allow := GenerateOptions{AllowMissingVars: true}
+	cases = append(cases,
+		parityCase{"no placeholders", "plain text", vars, allow},
+		parityCase{"unterminated", "{{a and {{b", vars, allow},
+		parityCase{"empty placeholder", "{{}} {{a}}", vars, allow},
+		parityCase{"single brace", "{a} {{a}}}", vars, allow},
+		parityCase{"missing raw", "{{{missing}}} {{{a}}}", vars, allow},
+		parityCase{"missing raw error", "{{{missing}}}", vars, GenerateOptions{}},
+		parityCase{"four braces", "{{{{a}}}}", vars, allow},
+		parityCase{"adjacent", "{{a}}{{b}}{{{a}}}{{b}}", vars, allow},
+		parityCase{"default with pipe", "{{c|x|y}} {{tags|join:;}}", vars, allow},
+		parityCase{"nested import", "{{@dir/{{a}}}} {{b}}", vars, allow},
+		parityCase{"escapes only", `\{{ and \}}`, vars, allow},
+		parityCase{"multiple missing", "{{x}} {{y}} {{x}}", vars, GenerateOptions{}},
+	)
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			expected, expectedErr := substituteVariablesRegex(tc.content, tc.vars, nil, tc.opts)
+			result, err := substituteVariables(tc.content, tc.vars, nil, tc.opts)
+
+			if !reflect.DeepEqual(err, expectedErr) {
+				t.Fatalf("Expected error %v, got %v", expectedErr, err)
+			}
+			if result != expected {
+				t.Errorf("Expected %q, got %q", expected, result)
+			}
+		})
+	}
+}
+
+func BenchmarkSubstituteVariables(b *testing.B) {
+	section := "@system:\nYou are a {{role|helpful}} assistant for {{company}}.\n" +
+		"Context: {{{context}}}\n{{@shared/footer}}\n@user:\n{{query}} \\{{literal}}\n"
+	content := strings.Repeat(section, 50)
+	vars := map[string]string{
+		"role":    "friendly",
+		"company": "ACME",
+		"context": "<data>some context</data>",
+		"query":   "What is Go?",
+	}
+
+	b.Run("scanner", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := substituteVariables(content, vars, nil, GenerateOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("regex", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := substituteVariablesRegex(content, vars, nil, GenerateOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestStripHTMLComments(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"no comments", "Hello {{name}}", "Hello {{name}}"},
+		{"inline", "Hello <!-- greeting -->{{name}}!", "Hello {{name}}!"},
+		{"own line", "@system:\n<!-- note for authors -->\nBe brief.", "@system:\nBe brief."},
+		{"indented line", "Line 1\n  <!-- note -->  \nLine 2", "Line 1\nLine 2"},
+		{"multi-line", "Line 1\n<!--\nTODO: rewrite\n-->\nLine 2", "Line 1\nLine 2"},
+		{"multi-line inline", "Line 1 <!-- a\nb --> end", "Line 1  end"},
+		{"several", "<!-- a -->\nA <!-- b --> B\n<!-- c -->", "A  B\n"},
+		{"inside raw placeholder", "{{{<!-- keep -->}}} <!-- drop -->x", "{{{<!-- keep -->}}} x"},
+		{"after raw placeholder", "{{{code}}}<!-- drop -->\nNext", "{{{code}}}\nNext"},
+		{"unterminated", "Text <!-- open", "Text <!-- open"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := stripHTMLComments(tt.content)
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected any
+	}{
+		{"gpt-4", "gpt-4"},
+		{"0.7", 0.7},
+		{"100", 100},
+		{"[go, testing]", []string{"go", "testing"}},
+		{"[single]", []string{"single"}},
+		{"[beta] model", "[beta] model"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if result := parseValue(tt.value); !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("parseValue(%q) = %#v, want %#v", tt.value, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCheckBraces(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		line    int
+		message string
+	}{
+		{"balanced", "Hi {{name}}, {{{code}}} {{@dir/{{kind}}}}", 0, ""},
+		{"escaped", `Use \{{name}} literally`, 0, ""},
+		{"json closers", `Reply as {"a": {"b": 1}}`, 0, ""},
+		{"extra closing brace", "{{name}}}", 0, ""},
+		{"unclosed variable", "Line 1\nHello {{name}\nLine 3", 2, "unclosed {{"},
+		{"unclosed raw", "Line 1\n\n{{{code", 3, "unclosed {{{"},
+		{"raw closed by double", "Code:\n{{{code}}\n{{name}}", 2, "{{{ is closed by }} instead of }}}"},
+		{"unclosed before valid", "{{a} and {{b}}", 1, "unclosed {{"},
+		{"unclosed import", "{{@header", 1, "unclosed {{"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issue := checkBraces(tt.content)
+			if tt.line == 0 {
+				if issue != nil {
+					t.Errorf("Unexpected issue: %v", issue)
+				}
+				return
+			}
+			if issue == nil {
+				t.Fatal("Expected an issue")
+			}
+			if issue.Line != tt.line || issue.Message != tt.message {
+				t.Errorf("Expected line %d %q, got line %d %q", tt.line, tt.message, issue.Line, issue.Message)
+			}
+		})
+	}
+}
+
+func TestFrontMatterDelimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		delimiter string
+		template  string
+		model     any
+		content   string
+	}{
+		{"default", "", "---\nmodel: gpt-4\n---\n@user:\nHi", "gpt-4", "Hi"},
+		{"explicit default", "---", "---\nmodel: gpt-4\n---\n@user:\nHi", "gpt-4", "Hi"},
+		{"custom", "~~~", "~~~\n# comment\nmodel: gpt-4\n~~~\n@user:\nHi", "gpt-4", "Hi"},
+		{"other fence is content", "~~~", "---\nmodel: gpt-4\n---", nil, "---\nmodel: gpt-4\n---"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := New(Config{
+				Source:               NewMockSource(map[string]string{"main.md": tt.template}),
+				FrontMatterDelimiter: tt.delimiter,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+
+			messages, metadata, err := engine.GenerateWithMetadata("main", nil, GenerateOptions{StrictMode: true})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if metadata["model"] != tt.model {
+				t.Errorf("Expected model %v, got %v", tt.model, metadata["model"])
+			}
+			if messages[0].Content != tt.content {
+				t.Errorf("Expected content %q, got %q", tt.content, messages[0].Content)
+			}
+		})
+	}
+
+	// Line numbers account for the custom front-matter block
+	engine, err := New(Config{
+		Source:               NewMockSource(map[string]string{"broken.md": "~~~\nmodel: gpt-4\n~~~\n@user:\nHello {{name"}),
+		FrontMatterDelimiter: "~~~",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	var parseErr *ParseError
+	if err := engine.ValidateTemplate("broken"); !errors.As(err, &parseErr) || parseErr.Line != 5 {
+		t.Errorf("Expected parse error at line 5, got %v", err)
+	}
+
+	// The TOML fence can't be configured for the default syntax
+	if _, err := New(Config{Source: NewMockSource(nil), FrontMatterDelimiter: "+++"}); err == nil {
+		t.Error("Expected error for the reserved +++ delimiter")
+	}
+}
@@ -0,0 +1,42 @@
+package echotemplates
+
+import (
+	"strings"
+
+	"github.com/mkozhukh/echo"
+)
+
+// TrimMessages removes leading and trailing whitespace from message content
+func TrimMessages(messages []echo.Message) ([]echo.Message, error) {
+	result := make([]echo.Message, len(messages))
+	for i, msg := range messages {
+		msg.Content = strings.TrimSpace(msg.Content)
+		result[i] = msg
+	}
+	return result, nil
+}
+
+// DropEmpty removes messages whose content is blank, like GenerateOptions.DropEmptyMessages
+func DropEmpty(messages []echo.Message) ([]echo.Message, error) {
+	result := make([]echo.Message, 0, len(messages))
+	for _, msg := range messages {
+		if strings.TrimSpace(msg.Content) != "" {
+			result = append(result, msg)
+		}
+	}
+	return result, nil
+}
+
+// MergeSameRole joins consecutive messages of the same role with a blank line,
+// for providers that require alternating turns
+func MergeSameRole(messages []echo.Message) ([]echo.Message, error) {
+	result := make([]echo.Message, 0, len(messages))
+	for _, msg := range messages {
+		if last := len(result) - 1; last >= 0 && result[last].Role == msg.Role {
+			result[last].Content += "\n\n" + msg.Content
+			continue
+		}
+		result = append(result, msg)
+	}
+	return result, nil
+}
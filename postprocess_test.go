@@ -0,0 +1,122 @@
+package echotemplates
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mkozhukh/echo"
+)
+
+func TestBuiltinPostProcessors(t *testing.T) {
+	messages := []echo.Message{
+		{Role: echo.System, Content: "  Be brief.  "},
+		{Role: echo.System, Content: "Be kind.\n"},
+		{Role: echo.User, Content: " \n "},
+		{Role: echo.User, Content: "Hi"},
+	}
+
+	tests := []struct {
+		name     string
+		process  MessagePostProcessor
+		expected []echo.Message
+	}{
+		{"trim", TrimMessages, []echo.Message{
+			{Role: echo.System, Content: "Be brief."},
+			{Role: echo.System, Content: "Be kind."},
+			{Role: echo.User, Content: ""},
+			{Role: echo.User, Content: "Hi"},
+		}},
+		{"drop empty", DropEmpty, []echo.Message{
+			{Role: echo.System, Content: "  Be brief.  "},
+			{Role: echo.System, Content: "Be kind.\n"},
+			{Role: echo.User, Content: "Hi"},
+		}},
+		{"merge same role", MergeSameRole, []echo.Message{
+			{Role: echo.System, Content: "  Be brief.  \n\nBe kind.\n"},
+			{Role: echo.User, Content: " \n \n\nHi"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.process(messages)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	// The input is left untouched
+	if messages[0].Content != "  Be brief.  " {
+		t.Errorf("Expected input messages to be unchanged, got %q", messages[0].Content)
+	}
+}
+
+func TestMessagePostProcessors(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"chat.md": "@system:\n  Be brief.  \n@system:\n{{extra}}\n@user:\nHi",
+	})
+
+	var calls []string
+	record := func(name string) MessagePostProcessor {
+		return func(messages []echo.Message) ([]echo.Message, error) {
+			calls = append(calls, name)
+			return messages, nil
+		}
+	}
+
+	t.Run("chained in order", func(t *testing.T) {
+		calls = nil
+		engine, err := New(Config{
+			Source:                source,
+			MessagePostProcessors: []MessagePostProcessor{record("first"), DropEmpty, TrimMessages, MergeSameRole, record("last")},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create engine: %v", err)
+		}
+
+		messages, err := engine.Generate("chat", map[string]any{"extra": " "})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := []echo.Message{
+			{Role: echo.System, Content: "Be brief."},
+			{Role: echo.User, Content: "Hi"},
+		}
+		if !reflect.DeepEqual(messages, expected) {
+			t.Errorf("Expected %q, got %q", expected, messages)
+		}
+		if !reflect.DeepEqual(calls, []string{"first", "last"}) {
+			t.Errorf("Expected processors to run in order, got %v", calls)
+		}
+	})
+
+	t.Run("error stops the chain", func(t *testing.T) {
+		calls = nil
+		errRejected := errors.New("rejected")
+		engine, err := New(Config{
+			Source: source,
+			MessagePostProcessors: []MessagePostProcessor{
+				record("first"),
+				func([]echo.Message) ([]echo.Message, error) { return nil, errRejected },
+				record("last"),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create engine: %v", err)
+		}
+
+		_, err = engine.Generate("chat", map[string]any{"extra": "Be kind."})
+		if !errors.Is(err, errRejected) || !strings.Contains(err.Error(), "post-processor 1") {
+			t.Errorf("Expected wrapped processor error, got %v", err)
+		}
+		if !reflect.DeepEqual(calls, []string{"first"}) {
+			t.Errorf("Expected the chain to stop at the error, got %v", calls)
+		}
+	})
+}
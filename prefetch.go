@@ -0,0 +1,71 @@
+package echotemplates
+
+import (
+	"strings"
+	"sync"
+)
+
+// prefetchedImport is an import loaded ahead of processing by prefetchImports
+type prefetchedImport struct {
+	path     string
+	template *parsedTemplate
+	err      error
+}
+
+// prefetchImports loads the sibling imports of a template in parallel when
+// Config.ImportConcurrency allows it, keyed by the import path as written
+// Only loading runs concurrently, paths are resolved in order and the imports are still expanded in order by
+// processImportsRecursive, so output, errors and cycle detection are unchanged
+// Fallback alternatives are loaded on demand
+func (e *templateEngine) prefetchImports(imports []string, vars map[string]string, opts GenerateOptions, currentTemplate string) map[string]*prefetchedImport {
+	limit := e.config.ImportConcurrency
+	if limit <= 1 || len(imports) < 2 {
+		return nil
+	}
+
+	var candidates []string
+	seen := make(map[string]bool)
+	for _, importExpr := range imports {
+		if opts.DisableDynamicImports && strings.Contains(importExpr, "{{") {
+			continue
+		}
+		candidate, _ := splitImportFragment(splitImportAlternatives(importExpr)[0])
+		if !seen[candidate] {
+			seen[candidate] = true
+			candidates = append(candidates, candidate)
+		}
+	}
+	if len(candidates) < 2 {
+		return nil
+	}
+
+	// Paths are resolved in order, variables may come from a VarResolver
+	// that is not safe for concurrent use
+	results := make([]*prefetchedImport, len(candidates))
+	for i, candidate := range candidates {
+		path := e.localize(e.resolveImportPath(candidate, vars, opts, currentTemplate), opts.Locale)
+		results[i] = &prefetchedImport{path: path, err: e.checkDynamicImport(candidate, vars, opts, currentTemplate)}
+	}
+
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for _, result := range results {
+		if result.err != nil {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			result.template, result.err = e.loadImport(result.path, opts)
+		}()
+	}
+	wg.Wait()
+
+	prefetched := make(map[string]*prefetchedImport, len(candidates))
+	for i, candidate := range candidates {
+		prefetched[candidate] = results[i]
+	}
+	return prefetched
+}
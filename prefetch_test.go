@@ -0,0 +1,181 @@
+package echotemplates
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrentSource tracks the peak number of concurrent opens
+type concurrentSource struct {
+	*MockSource
+	delay   time.Duration
+	current atomic.Int32
+	peak    atomic.Int32
+}
+
+func (s *concurrentSource) Open(path string) (io.ReadCloser, error) {
+	n := s.current.Add(1)
+	defer s.current.Add(-1)
+	for {
+		peak := s.peak.Load()
+		if n <= peak || s.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(s.delay)
+	return s.MockSource.Open(path)
+}
+
+func TestImportConcurrency(t *testing.T) {
+	templates := map[string]string{
+		"main.md":         "@system:\n{{@a}}\n{{@b}}\n{{@a}}\n{{@rules#short}}\n{{@missing|c}}\n\n@user:\n{{@parts/{{kind}}}} {{question}}",
+		"a.md":            "A {{@c}}",
+		"b.md":            "B {{@c}} {{@d}}",
+		"c.md":            "C",
+		"d.md":            "D",
+		"rules.md":        "{{#block short}}Short rules{{/block}}\nLong rules",
+		"parts/x.md":      "X",
+		"cycle.md":        "{{@e}} {{@f}}",
+		"e.md":            "E",
+		"f.md":            "F {{@cycle}}",
+		"broken.md":       "{{@a}} {{@nope}} {{@b}} {{@nope2}}",
+		"partial_user.md": "{{@a}} {{@virtual}}",
+	}
+	var many []string
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("parts/p%d", i)
+		templates[name+".md"] = fmt.Sprintf("P%d", i)
+		many = append(many, "{{@"+name+"}}")
+	}
+	templates["many.md"] = strings.Join(many, " ")
+
+	newEngine := func(concurrency int) (TemplateEngine, *concurrentSource) {
+		t.Helper()
+		source := &concurrentSource{MockSource: NewMockSource(templates), delay: 5 * time.Millisecond}
+		engine, err := New(Config{Source: source, ImportConcurrency: concurrency})
+		if err != nil {
+			t.Fatalf("Failed to create engine: %v", err)
+		}
+		engine.SetPartial("virtual", "V")
+		return engine, source
+	}
+	sequential, _ := newEngine(0)
+	parallel, source := newEngine(4)
+
+	tests := []struct {
+		name     string
+		template string
+		opts     GenerateOptions
+	}{
+		{"imports", "main", GenerateOptions{}},
+		{"cycle strict", "cycle", GenerateOptions{StrictMode: true}},
+		{"cycle", "cycle", GenerateOptions{}},
+		{"failures", "broken", GenerateOptions{}},
+		{"failures strict", "broken", GenerateOptions{StrictMode: true}},
+		{"partials", "partial_user", GenerateOptions{}},
+		{"many", "many", GenerateOptions{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := map[string]any{"kind": "x", "question": "Why?"}
+			expected, expectedMeta, expectedErr := sequential.GenerateWithMetadata(tt.template, vars, tt.opts)
+			messages, metadata, err := parallel.GenerateWithMetadata(tt.template, vars, tt.opts)
+
+			if fmt.Sprint(err) != fmt.Sprint(expectedErr) {
+				t.Fatalf("Expected error %v, got %v", expectedErr, err)
+			}
+			if !reflect.DeepEqual(messages, expected) {
+				t.Errorf("Expected %v, got %v", expected, messages)
+			}
+			if fmt.Sprint(metadata["_import_errors"]) != fmt.Sprint(expectedMeta["_import_errors"]) {
+				t.Errorf("Expected import errors %v, got %v", expectedMeta["_import_errors"], metadata["_import_errors"])
+			}
+		})
+	}
+
+	if peak := source.peak.Load(); peak < 2 || peak > 4 {
+		t.Errorf("Expected between 2 and 4 concurrent loads, got %d", peak)
+	}
+}
+
+func BenchmarkImportConcurrency(b *testing.B) {
+	templates := map[string]string{}
+	var imports []string
+	for i := 0; i < 16; i++ {
+		name := fmt.Sprintf("parts/p%d", i)
+		templates[name+".md"] = fmt.Sprintf("Part %d", i)
+		imports = append(imports, "{{@"+name+"}}")
+	}
+	templates["main.md"] = strings.Join(imports, "\n")
+
+	for _, concurrency := range []int{0, 8} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			source := &concurrentSource{MockSource: NewMockSource(templates), delay: time.Millisecond}
+			engine, err := New(Config{Source: source, DevMode: true, ImportConcurrency: concurrency})
+			if err != nil {
+				b.Fatalf("Failed to create engine: %v", err)
+			}
+			defer engine.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := engine.Generate("main", nil); err != nil {
+					b.Fatalf("Unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// exclusiveResolver fails the test when it is called concurrently,
+// its map writes are also reported by the race detector
+type exclusiveResolver struct {
+	t      *testing.T
+	active atomic.Int32
+	calls  map[string]int
+}
+
+func (r *exclusiveResolver) Resolve(name string) (string, bool) {
+	if r.active.Add(1) > 1 {
+		r.t.Errorf("Resolve(%q) called concurrently", name)
+	}
+	defer r.active.Add(-1)
+	time.Sleep(time.Millisecond)
+	r.calls[name]++
+	return strings.TrimPrefix(name, "kind"), true
+}
+
+// Run with -race to check the prefetch doesn't share the resolver between goroutines
+func TestImportConcurrencyResolver(t *testing.T) {
+	templates := map[string]string{}
+	var imports []string
+	for i := 0; i < 8; i++ {
+		templates[fmt.Sprintf("parts/%d.md", i)] = fmt.Sprintf("P%d", i)
+		imports = append(imports, fmt.Sprintf("{{@parts/{{kind%d}}}}", i))
+	}
+	templates["main.md"] = strings.Join(imports, " ")
+
+	source := &concurrentSource{MockSource: NewMockSource(templates), delay: 2 * time.Millisecond}
+	engine, err := New(Config{Source: source, ImportConcurrency: 8})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	resolver := &exclusiveResolver{t: t, calls: make(map[string]int)}
+	messages, err := engine.GenerateWithResolver("main", resolver)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if messages[0].Content != "P0 P1 P2 P3 P4 P5 P6 P7" {
+		t.Errorf("Unexpected content %q", messages[0].Content)
+	}
+	if source.peak.Load() < 2 {
+		t.Errorf("Expected imports to load concurrently, peak %d", source.peak.Load())
+	}
+}
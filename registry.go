@@ -0,0 +1,50 @@
+package echotemplates
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/mkozhukh/echo"
+)
+
+// ErrNoDefaultEngine is returned by GenerateNamed before SetDefaultEngine is called
+var ErrNoDefaultEngine = errors.New("no default engine registered, call SetDefaultEngine first")
+
+var (
+	defaultEngine   TemplateEngine
+	defaultEngineMu sync.RWMutex
+)
+
+// SetDefaultEngine registers the engine used by GenerateNamed, nil unregisters it
+// It is safe to call concurrently with generation
+func SetDefaultEngine(engine TemplateEngine) {
+	defaultEngineMu.Lock()
+	defer defaultEngineMu.Unlock()
+	defaultEngine = engine
+}
+
+// DefaultEngine returns the engine registered by SetDefaultEngine, or nil
+func DefaultEngine() TemplateEngine {
+	defaultEngineMu.RLock()
+	defer defaultEngineMu.RUnlock()
+	return defaultEngine
+}
+
+// GenerateNamed creates messages from a named template of the default engine
+func GenerateNamed(name string, vars map[string]any, opts ...GenerateOptions) ([]echo.Message, error) {
+	engine := DefaultEngine()
+	if engine == nil {
+		return nil, ErrNoDefaultEngine
+	}
+	return engine.Generate(name, vars, opts...)
+}
+
+// GenerateNamedWithMetadata creates messages from a named template of the default engine
+// and returns template metadata
+func GenerateNamedWithMetadata(name string, vars map[string]any, opts ...GenerateOptions) ([]echo.Message, map[string]any, error) {
+	engine := DefaultEngine()
+	if engine == nil {
+		return nil, nil, ErrNoDefaultEngine
+	}
+	return engine.GenerateWithMetadata(name, vars, opts...)
+}
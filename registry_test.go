@@ -0,0 +1,61 @@
+package echotemplates
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestDefaultEngine(t *testing.T) {
+	t.Cleanup(func() { SetDefaultEngine(nil) })
+
+	SetDefaultEngine(nil)
+	if _, err := GenerateNamed("greet", nil); !errors.Is(err, ErrNoDefaultEngine) {
+		t.Errorf("Expected ErrNoDefaultEngine, got %v", err)
+	}
+
+	engine, err := New(Config{Source: NewMockSource(map[string]string{
+		"greet.md": "---\nmodel: gpt-4\n---\n@user:\nHello {{name|World}}",
+	})})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	SetDefaultEngine(engine)
+
+	if DefaultEngine() != engine {
+		t.Error("Expected the registered engine")
+	}
+
+	messages, err := GenerateNamed("greet", map[string]any{"name": "Ann"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if messages[0].Content != "Hello Ann" {
+		t.Errorf("Expected 'Hello Ann', got %q", messages[0].Content)
+	}
+
+	_, metadata, err := GenerateNamedWithMetadata("greet", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if metadata["model"] != "gpt-4" {
+		t.Errorf("Expected model gpt-4, got %v", metadata["model"])
+	}
+
+	// Generation and registration can run concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := GenerateNamed("greet", nil); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			SetDefaultEngine(engine)
+		}()
+	}
+	wg.Wait()
+}
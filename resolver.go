@@ -0,0 +1,47 @@
+package echotemplates
+
+// VarResolver provides variable values on demand
+// Resolve is only called for variables referenced by the template
+type VarResolver interface {
+	// Resolve returns the value of a variable and whether it exists
+	Resolve(name string) (string, bool)
+}
+
+// VarResolverFunc adapts a function to the VarResolver interface
+type VarResolverFunc func(name string) (string, bool)
+
+// Resolve calls f(name)
+func (f VarResolverFunc) Resolve(name string) (string, bool) {
+	return f(name)
+}
+
+// cachingResolver remembers resolved values so that a variable used
+// several times in a template is resolved only once per generation
+type cachingResolver struct {
+	resolver VarResolver
+	values   map[string]resolvedValue
+}
+
+// resolvedValue is a cached resolver result
+type resolvedValue struct {
+	value string
+	ok    bool
+}
+
+// newCachingResolver wraps a resolver with a per-generation cache
+func newCachingResolver(resolver VarResolver) *cachingResolver {
+	return &cachingResolver{
+		resolver: resolver,
+		values:   make(map[string]resolvedValue),
+	}
+}
+
+// Resolve returns the cached value or asks the wrapped resolver
+func (r *cachingResolver) Resolve(name string) (string, bool) {
+	if cached, ok := r.values[name]; ok {
+		return cached.value, cached.ok
+	}
+	value, ok := r.resolver.Resolve(name)
+	r.values[name] = resolvedValue{value: value, ok: ok}
+	return value, ok
+}
@@ -0,0 +1,47 @@
+package echotemplates
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGenerateWithResolver(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"main.md":         "---\ndefault.tone: formal\n---\n@system:\n{{@styles/{{style}}}}\nTone: {{tone}}\n\n@user:\n{{query}} {{query}}",
+		"styles/short.md": "Keep it short for {{name}}.",
+		"unused.md":       "{{expensive}}",
+	})
+
+	engine, err := New(Config{Source: source})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	var requested []string
+	values := map[string]string{
+		"style":     "short",
+		"name":      "Ann",
+		"query":     "Hi",
+		"expensive": "computed",
+	}
+	resolver := VarResolverFunc(func(name string) (string, bool) {
+		requested = append(requested, name)
+		value, ok := values[name]
+		return value, ok
+	})
+
+	messages, err := engine.GenerateWithResolver("main", resolver)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(messages) != 2 || messages[0].Content != "Keep it short for Ann.\nTone: formal" || messages[1].Content != "Hi Hi" {
+		t.Errorf("Unexpected messages: %v", messages)
+	}
+
+	// Only referenced names are requested, each once
+	expected := []string{"style", "name", "tone", "query"}
+	if !reflect.DeepEqual(requested, expected) {
+		t.Errorf("Expected requests %v, got %v", expected, requested)
+	}
+}
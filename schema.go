@@ -0,0 +1,95 @@
+package echotemplates
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// builtinMetadataKeys are front-matter keys always accepted by StrictMetadata
+var builtinMetadataKeys = []string{"model", "temperature", "max_tokens", "description", "version", stopMetadataKey}
+
+// MetadataSchema describes constraints on template front-matter
+type MetadataSchema struct {
+	// Required lists keys every template must declare
+	Required []string
+
+	// Ranges limits numeric keys to an inclusive range
+	Ranges map[string]Range
+}
+
+// Range is an inclusive numeric range
+type Range struct {
+	Min float64
+	Max float64
+}
+
+// validate checks metadata against the schema and returns all violations
+func (s *MetadataSchema) validate(template string, metadata map[string]any) error {
+	var errs []error
+
+	for _, key := range s.Required {
+		if _, ok := metadata[key]; !ok {
+			errs = append(errs, &MetadataError{
+				Template: template,
+				Key:      key,
+				Message:  "required key is missing",
+			})
+		}
+	}
+
+	for key, r := range s.Ranges {
+		value, ok := metadata[key]
+		if !ok {
+			continue
+		}
+
+		var num float64
+		switch v := value.(type) {
+		case int:
+			num = float64(v)
+		case float64:
+			num = v
+		default:
+			errs = append(errs, &MetadataError{
+				Template: template,
+				Key:      key,
+				Message:  fmt.Sprintf("value %v is not a number", value),
+			})
+			continue
+		}
+
+		if num < r.Min || num > r.Max {
+			errs = append(errs, &MetadataError{
+				Template: template,
+				Key:      key,
+				Message:  fmt.Sprintf("value %v is out of range [%v, %v]", value, r.Min, r.Max),
+			})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkUnknownKeys reports metadata keys that are neither built-in, internal nor listed in known
+func checkUnknownKeys(template string, metadata map[string]any, known []string) error {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		if slices.Contains(builtinMetadataKeys, key) || slices.Contains(internalMetadataKeys, key) || slices.Contains(known, key) {
+			continue
+		}
+		errs = append(errs, &MetadataError{
+			Template: template,
+			Key:      key,
+			Message:  "unknown key",
+		})
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,121 @@
+package echotemplates
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMetadataSchema(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"good.md":      "---\nmodel: gpt-4\ndescription: Good\ntemperature: 0.7\n---\n@user:\nHi",
+		"no-desc.md":   "---\nmodel: gpt-4\n---\n@user:\nHi",
+		"too-hot.md":   "---\nmodel: gpt-4\ndescription: Hot\ntemperature: 2.5\n---\n@user:\nHi",
+		"bad-value.md": "---\nmodel: gpt-4\ndescription: Bad\ntemperature: warm\n---\n@user:\nHi",
+	})
+
+	engine, err := New(Config{
+		Source: source,
+		MetadataSchema: &MetadataSchema{
+			Required: []string{"model", "description"},
+			Ranges: map[string]Range{
+				"temperature": {Min: 0, Max: 2},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		wantError bool
+		key       string
+	}{
+		{"good", false, ""},
+		{"no-desc", true, "description"},
+		{"too-hot", true, "temperature"},
+		{"bad-value", true, "temperature"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := engine.ValidateTemplate(tt.name)
+			if !tt.wantError {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+
+			var metaErr *MetadataError
+			if !errors.As(err, &metaErr) {
+				t.Fatalf("Expected MetadataError, got %v", err)
+			}
+			if metaErr.Key != tt.key {
+				t.Errorf("Expected error for key %q, got %q", tt.key, metaErr.Key)
+			}
+		})
+	}
+
+	// ValidateAll reports every invalid template
+	err = engine.ValidateAll()
+	if err == nil {
+		t.Fatal("Expected ValidateAll to fail")
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); !ok || len(joined.Unwrap()) != 3 {
+		t.Errorf("Expected 3 errors, got %v", err)
+	}
+}
+
+func TestStrictMetadata(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"good.md":    "---\nmodel: gpt-4\ntemperature: 0.7\nstop: [END]\ntopic: go\ndefault.name: Bob\ntype.name: string\n---\n@user:\nHi {{name}}",
+		"typo.md":    "---\nmodel: gpt-4\ntemperatur: 0.7\n---\n@user:\nHi",
+		"unknown.md": "---\nmodel: gpt-4\nowner: me\n---\n@user:\nHi",
+	})
+
+	engine, err := New(Config{
+		Source:            source,
+		StrictMetadata:    true,
+		KnownMetadataKeys: []string{"topic"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		wantError bool
+		key       string
+	}{
+		{"good", false, ""},
+		{"typo", true, "temperatur"},
+		{"unknown", true, "owner"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := engine.ValidateTemplate(tt.name)
+			if !tt.wantError {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+
+			var metaErr *MetadataError
+			if !errors.As(err, &metaErr) {
+				t.Fatalf("Expected MetadataError, got %v", err)
+			}
+			if metaErr.Key != tt.key {
+				t.Errorf("Expected error for key %q, got %q", tt.key, metaErr.Key)
+			}
+		})
+	}
+
+	// Unknown keys are allowed without StrictMetadata
+	engine, _ = New(Config{Source: source})
+	if err := engine.ValidateTemplate("typo"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
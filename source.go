@@ -1,7 +1,10 @@
 package echotemplates
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
+	"path"
 	"time"
 )
 
@@ -30,6 +33,32 @@ type TemplateSource interface {
 	ResolveImport(importPath, currentPath string) string
 }
 
+// Pinger is implemented by sources with a cheaper reachability check than List,
+// e.g. a HEAD request or a database ping; used by TemplateEngine.Ping
+type Pinger interface {
+	Ping() error
+}
+
+// pingSource checks that a source is reachable, listing templates unless it implements Pinger
+func pingSource(source TemplateSource) error {
+	if pinger, ok := source.(Pinger); ok {
+		return pinger.Ping()
+	}
+	_, err := source.List()
+	return err
+}
+
+// ExtensionlessLister is implemented by sources that can list template files without
+// an extension; with Config.DisableAutoExtension set they are listed besides the List result
+type ExtensionlessLister interface {
+	ListExtensionless() ([]string, error)
+}
+
+// hasNoExtension reports whether the file name of a path has no extension
+func hasNoExtension(name string) bool {
+	return path.Ext(name) == ""
+}
+
 // TemplateInfo contains information about a template
 type TemplateInfo struct {
 	// Path is the template path
@@ -43,4 +72,14 @@ type TemplateInfo struct {
 
 	// IsDir indicates if this is a directory
 	IsDir bool
+
+	// ETag is an optional version identifier of the template content
+	// When set, the cache compares it instead of ModTime
+	ETag string
+}
+
+// contentETag builds an ETag from the template content
+func contentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
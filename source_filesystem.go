@@ -15,6 +15,7 @@ import (
 // FileSystemSource implements TemplateSource for filesystem-based templates
 type FileSystemSource struct {
 	rootDir    string
+	importDirs []string
 	watchChan  chan string
 	stopWatch  chan struct{}
 	watchErr   error
@@ -43,24 +44,51 @@ func NewFileSystemSource(rootDir string) (*FileSystemSource, error) {
 	}, nil
 }
 
-// Open returns a reader for the template content
-func (s *FileSystemSource) Open(path string) (io.ReadCloser, error) {
-	// Clean the path to prevent directory traversal
-	cleanPath := filepath.Clean(path)
-	if strings.HasPrefix(cleanPath, "..") || filepath.IsAbs(cleanPath) {
-		return nil, fmt.Errorf("invalid path: %s", path)
+// NewFileSystemSourceWithImportDirs creates a filesystem template source that also
+// looks up imports in importDirs, e.g. a shared prompt library outside rootDir
+// Imports found under rootDir take precedence, then the directories are probed in order
+func NewFileSystemSourceWithImportDirs(rootDir string, importDirs ...string) (*FileSystemSource, error) {
+	source, err := NewFileSystemSource(rootDir)
+	if err != nil {
+		return nil, err
 	}
 
-	fullPath := filepath.Join(s.rootDir, cleanPath)
+	for _, dir := range importDirs {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to access import directory: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("import path is not a directory: %s", dir)
+		}
 
-	// Verify the resolved path is within rootDir
-	absPath, err := filepath.Abs(fullPath)
+		absPath, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path: %w", err)
+		}
+		source.importDirs = append(source.importDirs, absPath)
+	}
+
+	return source, nil
+}
+
+// Ping checks that the root directory is still accessible
+func (s *FileSystemSource) Ping() error {
+	info, err := os.Stat(s.rootDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve path: %w", err)
+		return err
 	}
+	if !info.IsDir() {
+		return fmt.Errorf("root path is not a directory: %s", s.rootDir)
+	}
+	return nil
+}
 
-	if !strings.HasPrefix(absPath, s.rootDir) {
-		return nil, fmt.Errorf("path outside root directory: %s", path)
+// Open returns a reader for the template content
+func (s *FileSystemSource) Open(path string) (io.ReadCloser, error) {
+	fullPath, err := s.fullPath(path)
+	if err != nil {
+		return nil, err
 	}
 
 	return os.Open(fullPath)
@@ -68,10 +96,39 @@ func (s *FileSystemSource) Open(path string) (io.ReadCloser, error) {
 
 // Stat returns information about a template
 func (s *FileSystemSource) Stat(path string) (TemplateInfo, error) {
+	fullPath, err := s.fullPath(path)
+	if err != nil {
+		return TemplateInfo{}, err
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return TemplateInfo{}, err
+	}
+
+	// Derive a cheap ETag from modtime and size without reading the file
+	return TemplateInfo{
+		Path:    path,
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
+		IsDir:   info.IsDir(),
+		ETag:    fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+	}, nil
+}
+
+// fullPath maps a template path to a file path within rootDir
+// Absolute paths are only accepted inside import directories, as returned by ResolveImport
+func (s *FileSystemSource) fullPath(path string) (string, error) {
 	// Clean the path to prevent directory traversal
 	cleanPath := filepath.Clean(path)
-	if strings.HasPrefix(cleanPath, "..") || filepath.IsAbs(cleanPath) {
-		return TemplateInfo{}, fmt.Errorf("invalid path: %s", path)
+	if filepath.IsAbs(cleanPath) {
+		if s.inImportDir(cleanPath) {
+			return cleanPath, nil
+		}
+		return "", fmt.Errorf("invalid path: %s", path)
+	}
+	if strings.HasPrefix(cleanPath, "..") {
+		return "", fmt.Errorf("invalid path: %s", path)
 	}
 
 	fullPath := filepath.Join(s.rootDir, cleanPath)
@@ -79,28 +136,42 @@ func (s *FileSystemSource) Stat(path string) (TemplateInfo, error) {
 	// Verify the resolved path is within rootDir
 	absPath, err := filepath.Abs(fullPath)
 	if err != nil {
-		return TemplateInfo{}, fmt.Errorf("failed to resolve path: %w", err)
+		return "", fmt.Errorf("failed to resolve path: %w", err)
 	}
 
 	if !strings.HasPrefix(absPath, s.rootDir) {
-		return TemplateInfo{}, fmt.Errorf("path outside root directory: %s", path)
+		return "", fmt.Errorf("path outside root directory: %s", path)
 	}
 
-	info, err := os.Stat(fullPath)
-	if err != nil {
-		return TemplateInfo{}, err
-	}
+	return fullPath, nil
+}
 
-	return TemplateInfo{
-		Path:    path,
-		ModTime: info.ModTime(),
-		Size:    info.Size(),
-		IsDir:   info.IsDir(),
-	}, nil
+// inImportDir reports whether an absolute path is inside one of the import directories
+func (s *FileSystemSource) inImportDir(path string) bool {
+	for _, dir := range s.importDirs {
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
 }
 
 // List returns all available template paths
 func (s *FileSystemSource) List() ([]string, error) {
+	return s.walk(func(path string) bool {
+		return strings.HasSuffix(path, ".md")
+	})
+}
+
+// ListExtensionless returns the paths of files without an extension,
+// listed as templates when Config.DisableAutoExtension is set
+func (s *FileSystemSource) ListExtensionless() ([]string, error) {
+	return s.walk(hasNoExtension)
+}
+
+// walk returns the relative paths of the files below the root matching include
+func (s *FileSystemSource) walk(include func(path string) bool) ([]string, error) {
 	var templates []string
 
 	err := filepath.WalkDir(s.rootDir, func(path string, d fs.DirEntry, err error) error {
@@ -113,8 +184,7 @@ func (s *FileSystemSource) List() ([]string, error) {
 			return nil
 		}
 
-		// Only include .md files
-		if strings.HasSuffix(path, ".md") {
+		if include(path) {
 			// Get relative path
 			relPath, err := filepath.Rel(s.rootDir, path)
 			if err != nil {
@@ -134,6 +204,27 @@ func (s *FileSystemSource) List() ([]string, error) {
 	return templates, nil
 }
 
+// importDirFiles returns the import directories and the absolute paths of the
+// template files in them, so a snapshot can resolve imports without the disk
+func (s *FileSystemSource) importDirFiles() ([]string, []string, error) {
+	var files []string
+	for _, dir := range s.importDirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && (strings.HasSuffix(path, ".md") || hasNoExtension(path)) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return s.importDirs, files, nil
+}
+
 // Watch starts watching for changes
 func (s *FileSystemSource) Watch() (<-chan string, error) {
 	s.watchMutex.Lock()
@@ -171,9 +262,27 @@ func (s *FileSystemSource) StopWatch() error {
 	return nil
 }
 
-// ResolveImport allows customizing import resolution
+// ResolveImport looks up imports missing under rootDir in the import directories
+// and returns the absolute path of the first match
 func (s *FileSystemSource) ResolveImport(importPath, currentPath string) string {
-	// Default resolution - no custom behavior
+	if len(s.importDirs) == 0 || filepath.IsAbs(importPath) {
+		return ""
+	}
+	if _, err := s.Stat(importPath); err == nil {
+		return ""
+	}
+
+	for _, dir := range s.importDirs {
+		candidate := filepath.Join(dir, filepath.FromSlash(importPath))
+		if !s.inImportDir(candidate) {
+			continue
+		}
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+	}
+
+	// Default resolution
 	return ""
 }
 
@@ -0,0 +1,173 @@
+package echotemplates
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FSSource implements TemplateSource for any fs.FS,
+// e.g. os.DirFS, embed.FS or fstest.MapFS
+type FSSource struct {
+	fs      fs.FS
+	rootDir string
+
+	// etags memoizes content hashes per path, as a fsEntryETag
+	etags sync.Map
+
+	// immutable is set for embed.FS, whose files never change
+	immutable bool
+}
+
+// fsEntryETag is the content hash of a file, valid while its modtime and size are unchanged
+// Without a modtime a same-size edit can't be told apart, so such files are hashed on
+// every Stat, except for embed.FS where each one is read and hashed once
+type fsEntryETag struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+// NewFSSource creates a new template source reading from fsys below rootDir
+func NewFSSource(fsys fs.FS, rootDir string) *FSSource {
+	// Normalize root directory
+	rootDir = strings.TrimPrefix(rootDir, "/")
+	rootDir = strings.TrimSuffix(rootDir, "/")
+
+	_, immutable := fsys.(embed.FS)
+	return &FSSource{
+		fs:        fsys,
+		rootDir:   rootDir,
+		immutable: immutable,
+	}
+}
+
+// fullPath returns the path of a template inside the filesystem
+func (s *FSSource) fullPath(name string) string {
+	if s.rootDir == "" {
+		return name
+	}
+	return path.Join(s.rootDir, name)
+}
+
+// Open returns a reader for the template content
+func (s *FSSource) Open(name string) (io.ReadCloser, error) {
+	data, err := fs.ReadFile(s.fs, s.fullPath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Stat returns information about a template
+func (s *FSSource) Stat(name string) (TemplateInfo, error) {
+	fullPath := s.fullPath(name)
+
+	info, err := fs.Stat(s.fs, fullPath)
+	if err != nil {
+		return TemplateInfo{}, err
+	}
+
+	// Not every filesystem has meaningful modtimes, so identify files by content
+	etag := ""
+	if !info.IsDir() {
+		memoize := s.immutable || !info.ModTime().IsZero()
+		cached, ok := s.etags.Load(fullPath)
+		if entry, _ := cached.(fsEntryETag); ok && memoize && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+			etag = entry.etag
+		} else {
+			data, err := fs.ReadFile(s.fs, fullPath)
+			if err != nil {
+				return TemplateInfo{}, err
+			}
+			etag = contentETag(data)
+			if memoize {
+				s.etags.Store(fullPath, fsEntryETag{modTime: info.ModTime(), size: info.Size(), etag: etag})
+			}
+		}
+	}
+
+	return TemplateInfo{
+		Path:    name,
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
+		IsDir:   info.IsDir(),
+		ETag:    etag,
+	}, nil
+}
+
+// List returns all available template paths
+func (s *FSSource) List() ([]string, error) {
+	return s.walk(func(path string) bool {
+		return strings.HasSuffix(path, ".md")
+	})
+}
+
+// ListExtensionless returns the paths of files without an extension,
+// listed as templates when Config.DisableAutoExtension is set
+func (s *FSSource) ListExtensionless() ([]string, error) {
+	return s.walk(hasNoExtension)
+}
+
+// walk returns the paths relative to the root of the files matching include
+func (s *FSSource) walk(include func(path string) bool) ([]string, error) {
+	var templates []string
+
+	rootToWalk := "."
+	if s.rootDir != "" {
+		rootToWalk = s.rootDir
+	}
+
+	err := fs.WalkDir(s.fs, rootToWalk, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip directories
+		if d.IsDir() {
+			return nil
+		}
+
+		if include(path) {
+			// Get relative path from root
+			relPath := path
+			if s.rootDir != "" {
+				relPath = strings.TrimPrefix(path, s.rootDir+"/")
+			}
+			templates = append(templates, relPath)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk filesystem: %w", err)
+	}
+
+	sort.Strings(templates)
+	return templates, nil
+}
+
+// Watch returns nil as fs.FS has no change notifications
+func (s *FSSource) Watch() (<-chan string, error) {
+	return nil, nil
+}
+
+// StopWatch is a no-op for fs.FS sources
+func (s *FSSource) StopWatch() error {
+	return nil
+}
+
+// ResolveImport allows customizing import resolution
+func (s *FSSource) ResolveImport(importPath, currentPath string) string {
+	// Default resolution - no custom behavior
+	return ""
+}
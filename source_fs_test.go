@@ -0,0 +1,125 @@
+package echotemplates
+
+import (
+	"io/fs"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// countingFS counts the files opened from the wrapped filesystem
+type countingFS struct {
+	fs.FS
+	opens atomic.Int32
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	c.opens.Add(1)
+	return c.FS.Open(name)
+}
+
+func TestFSSource(t *testing.T) {
+	fsys := fstest.MapFS{
+		"prompts/chat.md":        {Data: []byte("---\nmodel: gpt-4\n---\n@system:\n{{@shared/tone}}\n@user:\n{{query}}")},
+		"prompts/shared/tone.md": {Data: []byte("Be {{tone|friendly}}.")},
+		"prompts/notes.txt":      {Data: []byte("not a template")},
+		"other/skip.md":          {Data: []byte("outside of root")},
+	}
+
+	source := NewFSSource(fsys, "/prompts/")
+
+	t.Run("List", func(t *testing.T) {
+		templates, err := source.List()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := []string{"chat.md", "shared/tone.md"}
+		if !reflect.DeepEqual(templates, expected) {
+			t.Errorf("Expected %v, got %v", expected, templates)
+		}
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		info, err := source.Stat("shared/tone.md")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if info.Path != "shared/tone.md" || info.Size != int64(len("Be {{tone|friendly}}.")) || info.ETag == "" {
+			t.Errorf("Unexpected info %+v", info)
+		}
+		if _, err := source.Stat("missing.md"); err == nil {
+			t.Error("Expected error for missing template")
+		}
+	})
+
+	t.Run("Generate", func(t *testing.T) {
+		engine, err := New(Config{Source: source})
+		if err != nil {
+			t.Fatalf("Failed to create engine: %v", err)
+		}
+
+		messages, metadata, err := engine.GenerateWithMetadata("chat", map[string]any{"query": "Hi"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(messages) != 2 || messages[0].Content != "Be friendly." || messages[1].Content != "Hi" {
+			t.Errorf("Unexpected messages %v", messages)
+		}
+		if metadata["model"] != "gpt-4" {
+			t.Errorf("Expected model gpt-4, got %v", metadata["model"])
+		}
+	})
+
+	t.Run("ETag memoized", func(t *testing.T) {
+		counting := &countingFS{FS: fstest.MapFS{
+			"prompts/chat.md": {Data: []byte("Hi"), ModTime: time.Now()},
+		}}
+		source := NewFSSource(counting, "prompts")
+		first, err := source.Stat("chat.md")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		opens := counting.opens.Load()
+		second, err := source.Stat("chat.md")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if second.ETag != first.ETag {
+			t.Errorf("Expected the same ETag, got %q and %q", first.ETag, second.ETag)
+		}
+		if n := counting.opens.Load() - opens; n > 1 {
+			t.Errorf("Expected the content to be hashed once, got %d more opens", n)
+		}
+	})
+
+	t.Run("ETag without modtime", func(t *testing.T) {
+		fsys := fstest.MapFS{"chat.md": {Data: []byte("Hello")}}
+		source := NewFSSource(fsys, "")
+		first, err := source.Stat("chat.md")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		// A same-size edit is only visible in the content
+		fsys["chat.md"].Data = []byte("Hallo")
+		second, err := source.Stat("chat.md")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if second.ETag == first.ETag {
+			t.Errorf("Expected the ETag to change with the content, got %q", second.ETag)
+		}
+	})
+
+	t.Run("NoRoot", func(t *testing.T) {
+		templates, err := NewFSSource(fsys, "").List()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(templates) != 3 {
+			t.Errorf("Expected 3 templates, got %v", templates)
+		}
+	})
+}
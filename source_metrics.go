@@ -0,0 +1,114 @@
+package echotemplates
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// SourceStat contains access statistics for a single template path
+type SourceStat struct {
+	// Opens is the number of Open calls
+	Opens int
+
+	// Stats is the number of Stat calls
+	Stats int
+
+	// Lists is the number of List calls (recorded under the empty path)
+	Lists int
+
+	// TotalLatency is the time spent in all calls
+	TotalLatency time.Duration
+
+	// MaxLatency is the slowest single call
+	MaxLatency time.Duration
+}
+
+// MetricsSource wraps another TemplateSource and records access statistics
+type MetricsSource struct {
+	source TemplateSource
+	mu     sync.Mutex
+	stats  map[string]*SourceStat
+}
+
+// NewMetricsSource creates a new metrics source wrapping the given source
+func NewMetricsSource(source TemplateSource) *MetricsSource {
+	return &MetricsSource{
+		source: source,
+		stats:  make(map[string]*SourceStat),
+	}
+}
+
+// Open returns a reader for the template content
+func (m *MetricsSource) Open(path string) (io.ReadCloser, error) {
+	start := time.Now()
+	reader, err := m.source.Open(path)
+	m.record(path, time.Since(start), func(s *SourceStat) { s.Opens++ })
+	return reader, err
+}
+
+// Stat returns information about a template
+func (m *MetricsSource) Stat(path string) (TemplateInfo, error) {
+	start := time.Now()
+	info, err := m.source.Stat(path)
+	m.record(path, time.Since(start), func(s *SourceStat) { s.Stats++ })
+	return info, err
+}
+
+// List returns all available template paths
+func (m *MetricsSource) List() ([]string, error) {
+	start := time.Now()
+	templates, err := m.source.List()
+	m.record("", time.Since(start), func(s *SourceStat) { s.Lists++ })
+	return templates, err
+}
+
+// Watch delegates to the wrapped source
+func (m *MetricsSource) Watch() (<-chan string, error) {
+	return m.source.Watch()
+}
+
+// StopWatch delegates to the wrapped source
+func (m *MetricsSource) StopWatch() error {
+	return m.source.StopWatch()
+}
+
+// ResolveImport delegates to the wrapped source
+func (m *MetricsSource) ResolveImport(importPath, currentPath string) string {
+	return m.source.ResolveImport(importPath, currentPath)
+}
+
+// Ping checks that the wrapped source is reachable
+func (m *MetricsSource) Ping() error {
+	return pingSource(m.source)
+}
+
+// Metrics returns a snapshot of the collected statistics keyed by path
+func (m *MetricsSource) Metrics() map[string]SourceStat {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make(map[string]SourceStat, len(m.stats))
+	for path, stat := range m.stats {
+		result[path] = *stat
+	}
+	return result
+}
+
+// record updates statistics for a path
+func (m *MetricsSource) record(path string, latency time.Duration, update func(*SourceStat)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stat, ok := m.stats[path]
+	if !ok {
+		stat = &SourceStat{}
+		m.stats[path] = stat
+	}
+
+	update(stat)
+	stat.TotalLatency += latency
+	if latency > stat.MaxLatency {
+		stat.MaxLatency = latency
+	}
+}
@@ -0,0 +1,60 @@
+package echotemplates
+
+import (
+	"testing"
+)
+
+func TestMetricsSource(t *testing.T) {
+	mock := NewMockSource(map[string]string{
+		"main.md":   "@system:\n{{@header}}\nHello {{name}}",
+		"header.md": "Header",
+	})
+
+	metrics := NewMetricsSource(mock)
+
+	// Test that it implements TemplateSource interface
+	var _ TemplateSource = metrics
+
+	engine, err := New(Config{
+		Source: metrics,
+		DefaultOptions: GenerateOptions{
+			DisableCache: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := engine.Generate("main", map[string]any{"name": "Ann"}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if _, err := engine.ListTemplates(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	stats := metrics.Metrics()
+
+	if stats["main.md"].Opens != 3 || stats["main.md"].Stats != 3 {
+		t.Errorf("Expected 3 opens and stats for main.md, got %+v", stats["main.md"])
+	}
+	if stats["header.md"].Opens != 3 || stats["header.md"].Stats != 3 {
+		t.Errorf("Expected 3 opens and stats for header.md, got %+v", stats["header.md"])
+	}
+	if stats[""].Lists != 1 {
+		t.Errorf("Expected 1 list call, got %+v", stats[""])
+	}
+
+	// Delegated methods
+	if ch, err := metrics.Watch(); ch != nil || err != nil {
+		t.Error("Expected Watch to delegate to mock source")
+	}
+	if err := metrics.StopWatch(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if path := metrics.ResolveImport("a.md", "b.md"); path != "" {
+		t.Errorf("Expected empty import resolution, got %q", path)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/fs"
 	"sort"
 	"strings"
 	"time"
@@ -31,7 +32,7 @@ func NewMockSource(templates map[string]string) *MockSource {
 func (m *MockSource) Open(path string) (io.ReadCloser, error) {
 	content, exists := m.templates[path]
 	if !exists {
-		return nil, fmt.Errorf("template not found: %s", path)
+		return nil, fmt.Errorf("template not found: %s: %w", path, fs.ErrNotExist)
 	}
 
 	return io.NopCloser(bytes.NewReader([]byte(content))), nil
@@ -41,7 +42,7 @@ func (m *MockSource) Open(path string) (io.ReadCloser, error) {
 func (m *MockSource) Stat(path string) (TemplateInfo, error) {
 	content, exists := m.templates[path]
 	if !exists {
-		return TemplateInfo{}, fmt.Errorf("template not found: %s", path)
+		return TemplateInfo{}, fmt.Errorf("template not found: %s: %w", path, fs.ErrNotExist)
 	}
 
 	return TemplateInfo{
@@ -49,6 +50,7 @@ func (m *MockSource) Stat(path string) (TemplateInfo, error) {
 		ModTime: time.Now(),
 		Size:    int64(len(content)),
 		IsDir:   false,
+		ETag:    contentETag([]byte(content)),
 	}, nil
 }
 
@@ -66,6 +68,19 @@ func (m *MockSource) List() ([]string, error) {
 	return paths, nil
 }
 
+// ListExtensionless returns the paths of templates without an extension
+func (m *MockSource) ListExtensionless() ([]string, error) {
+	var paths []string
+	for path := range m.templates {
+		if hasNoExtension(path) {
+			paths = append(paths, path)
+		}
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
+
 // Watch returns nil channel - watching not supported for mock
 func (m *MockSource) Watch() (<-chan string, error) {
 	return nil, nil
@@ -0,0 +1,199 @@
+package echotemplates
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// snapshotSource is an immutable copy of the templates of another source,
+// see TemplateEngine.Snapshot
+type snapshotSource struct {
+	templates map[string][]byte
+	infos     map[string]TemplateInfo
+
+	// importDirs are the copied import directories of a FileSystemSource,
+	// their files are stored under absolute paths and not listed
+	importDirs []string
+
+	// origin resolves imports of other sources, once per import
+	origin     TemplateSource
+	resolvedMu sync.Mutex
+	resolved   map[string]string
+}
+
+// importDirSource is implemented by sources resolving imports from extra directories
+type importDirSource interface {
+	importDirFiles() (dirs []string, files []string, err error)
+}
+
+// newSnapshotSource copies the templates at paths of source into memory
+func newSnapshotSource(source TemplateSource, paths []string) (*snapshotSource, error) {
+	snapshot := &snapshotSource{
+		templates: make(map[string][]byte, len(paths)),
+		infos:     make(map[string]TemplateInfo, len(paths)),
+		origin:    source,
+	}
+	if dirSource, ok := source.(importDirSource); ok {
+		dirs, files, err := dirSource.importDirFiles()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list import directories: %w", err)
+		}
+		snapshot.importDirs = dirs
+		paths = append(slices.Clone(paths), files...)
+	}
+
+	for _, path := range paths {
+		info, err := source.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat template %s: %w", path, err)
+		}
+		file, err := source.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open template %s: %w", path, err)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template %s: %w", path, err)
+		}
+
+		// Size and ETag describe the copied content
+		info.Path = path
+		info.Size = int64(len(data))
+		info.ETag = contentETag(data)
+		snapshot.templates[path] = data
+		snapshot.infos[path] = info
+	}
+	return snapshot, nil
+}
+
+// Open returns a reader for the copied template content
+func (s *snapshotSource) Open(path string) (io.ReadCloser, error) {
+	data, ok := s.templates[path]
+	if !ok {
+		return nil, fmt.Errorf("template not found: %s: %w", path, fs.ErrNotExist)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Stat returns information about a copied template
+func (s *snapshotSource) Stat(path string) (TemplateInfo, error) {
+	info, ok := s.infos[path]
+	if !ok {
+		return TemplateInfo{}, fmt.Errorf("template not found: %s: %w", path, fs.ErrNotExist)
+	}
+	return info, nil
+}
+
+// List returns the copied template paths
+func (s *snapshotSource) List() ([]string, error) {
+	return s.list(func(path string) bool {
+		return strings.HasSuffix(path, ".md")
+	}), nil
+}
+
+// ListExtensionless returns the copied template paths without an extension
+func (s *snapshotSource) ListExtensionless() ([]string, error) {
+	return s.list(hasNoExtension), nil
+}
+
+// list returns the sorted copied paths matching include
+func (s *snapshotSource) list(include func(path string) bool) []string {
+	var paths []string
+	for path := range s.templates {
+		if include(path) && !filepath.IsAbs(path) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Watch returns nil channel - a snapshot never changes
+func (s *snapshotSource) Watch() (<-chan string, error) {
+	return nil, nil
+}
+
+// StopWatch is a no-op for snapshots
+func (s *snapshotSource) StopWatch() error {
+	return nil
+}
+
+// ResolveImport looks up imports missing from the copied templates in the copied
+// import directories, like FileSystemSource does
+// Other sources are asked once per import (up to maxImportPaths), so later answers stay the same;
+// resolved paths outside the snapshot fail to load like missing templates
+func (s *snapshotSource) ResolveImport(importPath, currentPath string) string {
+	if s.importDirs != nil {
+		if _, ok := s.templates[importPath]; ok || filepath.IsAbs(importPath) {
+			return ""
+		}
+		for _, dir := range s.importDirs {
+			candidate := filepath.Join(dir, filepath.FromSlash(importPath))
+			if _, ok := s.templates[candidate]; ok {
+				return candidate
+			}
+		}
+		return ""
+	}
+
+	s.resolvedMu.Lock()
+	defer s.resolvedMu.Unlock()
+	key := currentPath + "\x00" + importPath
+	if resolved, ok := s.resolved[key]; ok {
+		return resolved
+	}
+	resolved := s.origin.ResolveImport(importPath, currentPath)
+	if s.resolved == nil {
+		s.resolved = make(map[string]string)
+	}
+	if len(s.resolved) < maxImportPaths {
+		s.resolved[key] = resolved
+	}
+	return resolved
+}
+
+// Snapshot returns an engine reading from a copy of the current source contents,
+// so a multi-step generation sees the same templates even if files change meanwhile
+// Virtual partials and import directories are copied; the snapshot caches templates
+// and doesn't watch files
+func (e *templateEngine) Snapshot() (TemplateEngine, error) {
+	// String templates carry their content in the name
+	if _, isStringSource := e.source.(*stringSource); isStringSource {
+		return e, nil
+	}
+
+	paths, err := e.sourcePaths()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	source, err := newSnapshotSource(e.source, paths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot template source: %w", err)
+	}
+
+	config := e.config
+	config.Source = source
+	config.DevMode = false
+	config.PrewarmInterval = 0
+	config.Cache = nil
+
+	snapshot, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+
+	e.partialsMu.RLock()
+	defer e.partialsMu.RUnlock()
+	for path, content := range e.partials {
+		snapshot.SetPartial(path, content)
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,130 @@
+package echotemplates
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("chat.md", "@system:\n{{@persona}}\n@user:\n{{question}}")
+	write("persona.md", "You are helpful.")
+	write("signed.md", "@user:\n{{@footer}}")
+
+	source, err := NewFileSystemSource(dir)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	engine, err := New(Config{Source: source, DevMode: true})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+	engine.SetPartial("footer", "Thanks!")
+
+	snapshot, err := engine.Snapshot()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Change the source after taking the snapshot
+	write("persona.md", "You are terse.")
+	write("extra.md", "@user:\nNew")
+	if err := os.Remove(filepath.Join(dir, "chat.md")); err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]any{"question": "Hi"}
+	for i := 0; i < 2; i++ {
+		messages, err := snapshot.Generate("chat", vars)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(messages) != 2 || messages[0].Content != "You are helpful." {
+			t.Errorf("Expected snapshot content, got %v", messages)
+		}
+	}
+
+	if _, err := engine.Generate("chat", vars); err == nil {
+		t.Error("Expected the live engine to miss the removed template")
+	}
+	messages, err := engine.Generate("persona", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if messages[0].Content != "You are terse." {
+		t.Errorf("Expected live content, got %v", messages)
+	}
+
+	templates, err := snapshot.ListTemplates()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(templates) != 3 || snapshot.TemplateExists("extra") {
+		t.Errorf("Expected the snapshot listing, got %v", templates)
+	}
+
+	messages, err = snapshot.Generate("signed", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if messages[0].Content != "Thanks!" {
+		t.Errorf("Expected copied partial, got %v", messages)
+	}
+}
+
+func TestSnapshotImportDirs(t *testing.T) {
+	dir := t.TempDir()
+	shared := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(dir, "chat.md"), "@system:\n{{@rules}}\n{{@tone}}\n@user:\nHi")
+	write(filepath.Join(shared, "rules.md"), "Shared rules.")
+	write(filepath.Join(shared, "tone.md"), "Shared tone.")
+
+	source, err := NewFileSystemSourceWithImportDirs(dir, shared)
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	engine, err := New(Config{Source: source})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	snapshot, err := engine.Snapshot()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Neither a changed shared file nor a new local override reaches the snapshot
+	write(filepath.Join(shared, "rules.md"), "Changed rules.")
+	write(filepath.Join(dir, "tone.md"), "Local tone.")
+
+	messages, err := snapshot.Generate("chat", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if messages[0].Content != "Shared rules.\nShared tone." {
+		t.Errorf("Expected the copied imports, got %q", messages[0].Content)
+	}
+
+	// Import directory files are not templates of the snapshot
+	templates, err := snapshot.ListTemplates()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(templates) != 1 || templates[0] != "chat" {
+		t.Errorf("Expected only chat, got %v", templates)
+	}
+}
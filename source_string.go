@@ -91,3 +91,33 @@ func GenerateWithMetadata(content string, vars map[string]any, opts ...GenerateO
 	// The engine will call source.Open(content) which returns the content
 	return engine.GenerateWithMetadata(content, vars, opts...)
 }
+
+// GenerateReader creates messages from template content read from r and returns metadata
+func GenerateReader(r io.Reader, vars map[string]any, opts ...GenerateOptions) ([]echo.Message, map[string]any, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read template: %w", err)
+	}
+
+	return GenerateWithMetadata(string(data), vars, opts...)
+}
+
+// GenerateMultimodal creates messages with text and image parts from a string template
+func GenerateMultimodal(content string, vars map[string]any, opts ...GenerateOptions) ([]MultimodalMessage, map[string]any, error) {
+	engine, err := getStringEngine()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize string engine: %w", err)
+	}
+
+	return engine.GenerateMultimodal(content, vars, opts...)
+}
+
+// GenerateText creates a single string from a string template using DefaultTextFormatter
+func GenerateText(content string, vars map[string]any, opts ...GenerateOptions) (string, map[string]any, error) {
+	engine, err := getStringEngine()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to initialize string engine: %w", err)
+	}
+
+	return engine.GenerateText(content, vars, opts...)
+}
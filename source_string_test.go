@@ -1,7 +1,12 @@
 package echotemplates
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestStringGeneration(t *testing.T) {
@@ -78,6 +83,36 @@ default.name: Friend
 	}
 }
 
+func TestStringLiteralImports(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		vars     map[string]any
+		expected string
+	}{
+		{"mention", "Reply to {{@mention}} about {{topic}}", map[string]any{"topic": "Go"}, "Reply to {{@mention}} about Go"},
+		{"nested placeholder", "Ask {{@team/{{name}}}}", map[string]any{"name": "ops"}, "Ask {{@team/ops}}"},
+		{"escaped braces", `{{@a}} and \{{b\}}`, nil, "{{@a}} and {{b}}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := Generate(tt.content, tt.vars, GenerateOptions{AllowLiteralImports: true})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if messages[0].Content != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, messages[0].Content)
+			}
+		})
+	}
+
+	// Without the option imports still fail
+	if _, err := Generate("Reply to {{@mention}}", nil); err == nil {
+		t.Error("Expected error for import in string template")
+	}
+}
+
 func TestStringGenerationWithMetadata(t *testing.T) {
 	content := `---
 temperature: 0.7
@@ -155,3 +190,44 @@ func TestStringSource(t *testing.T) {
 		t.Errorf("ResolveImport() should return empty string")
 	}
 }
+
+func TestStringGenerateText(t *testing.T) {
+	text, _, err := GenerateText("@system:\nBe {{tone}}.\n\n@user:\nHi", map[string]any{"tone": "brief"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := "System: Be brief.\n\nUser: Hi"
+	if text != expected {
+		t.Errorf("Expected %q, got %q", expected, text)
+	}
+}
+
+func TestGenerateReader(t *testing.T) {
+	content := "---\nmodel: gpt-4\n---\n@user:\nHello {{name}}"
+
+	readers := map[string]io.Reader{
+		"strings.Reader": strings.NewReader(content),
+		"bytes.Buffer":   bytes.NewBufferString(content),
+	}
+
+	for name, reader := range readers {
+		t.Run(name, func(t *testing.T) {
+			messages, metadata, err := GenerateReader(reader, map[string]any{"name": "Ann"})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(messages) != 1 || messages[0].Content != "Hello Ann" {
+				t.Errorf("Unexpected messages: %v", messages)
+			}
+			if metadata["model"] != "gpt-4" {
+				t.Errorf("Expected model gpt-4, got %v", metadata["model"])
+			}
+		})
+	}
+
+	_, _, err := GenerateReader(iotest.ErrReader(errors.New("broken stream")), nil)
+	if err == nil || !strings.Contains(err.Error(), "broken stream") {
+		t.Errorf("Expected read error, got %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+package echotemplates
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tomlFrontMatterDelimiter fences TOML front-matter, as used by static site generators
+const tomlFrontMatterDelimiter = "+++"
+
+// scanTOMLFrontMatter extracts +++ fenced TOML front-matter, reporting malformed lines
+// like scanFrontMatter. Tables and dotted keys follow the same conventions as
+// the --- block: [defaults] or default.name, [fallbacks] or fallback.name,
+// [validate] or validate.name, [render] or render.name, [type] or type.name
+// and [variants.name];
+// other tables become nested maps.
+// Values must fit on one line, arrays become []string
+func scanTOMLFrontMatter(text string) (map[string]any, string, []*ParseError) {
+	var issues []*ParseError
+	seen := make(map[string]int)
+	metadata := make(map[string]any)
+	defaults := make(map[string]string)
+	metadata["defaults"] = defaults
+	variants := make(map[string]map[string]any)
+	fallbacks := make(map[string]string)
+	validators := make(map[string]string)
+	render := make(map[string]string)
+	types := make(map[string]string)
+
+	lines := strings.Split(text, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], "\r")
+	}
+
+	end := len(lines)
+	for i := 1; i < len(lines); i++ {
+		if lines[i] == tomlFrontMatterDelimiter {
+			end = i
+			break
+		}
+	}
+
+	table := ""
+	for i := 1; i < end; i++ {
+		lineNum := i + 1
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// [table] headers apply to the following keys
+		if strings.HasPrefix(trimmed, "[") {
+			name, ok := parseTOMLTable(trimmed)
+			if !ok {
+				issues = append(issues, &ParseError{
+					Line:    lineNum,
+					Message: fmt.Sprintf("malformed front-matter table %q, expected [name]", trimmed),
+				})
+				continue
+			}
+			if issue := checkDuplicateKey(seen, "["+name+"]", lineNum); issue != nil {
+				issues = append(issues, issue)
+			}
+			table = name
+			continue
+		}
+
+		key, raw, ok := strings.Cut(trimmed, "=")
+		key = strings.Trim(strings.TrimSpace(key), `"'`)
+		if !ok || key == "" {
+			issues = append(issues, &ParseError{
+				Line:    lineNum,
+				Message: fmt.Sprintf("malformed front-matter line %q, expected key = value", trimmed),
+			})
+			continue
+		}
+		raw = strings.TrimSpace(raw)
+		value, err := parseTOMLValue(raw)
+		if err != nil {
+			issues = append(issues, &ParseError{
+				Line:    lineNum,
+				Message: fmt.Sprintf("invalid front-matter value for %q: %v", key, err),
+			})
+			continue
+		}
+
+		if table != "" {
+			key = table + "." + key
+		}
+		if issue := checkDuplicateKey(seen, key, lineNum); issue != nil {
+			issues = append(issues, issue)
+		}
+
+		switch {
+		case hasAnyPrefix(key, "default.", "defaults."):
+			_, name, _ := strings.Cut(key, ".")
+			defaults[name] = toString(value)
+		case hasAnyPrefix(key, "fallback.", "fallbacks."):
+			_, name, _ := strings.Cut(key, ".")
+			fallbacks[name] = toString(value)
+		case strings.HasPrefix(key, "validate."):
+			if issue := addValidator(validators, strings.TrimPrefix(key, "validate."), toString(value), lineNum); issue != nil {
+				issues = append(issues, issue)
+			}
+		case strings.HasPrefix(key, "render."):
+			if issue := addRenderMode(render, strings.TrimPrefix(key, "render."), toString(value), lineNum); issue != nil {
+				issues = append(issues, issue)
+			}
+		case strings.HasPrefix(key, "type."):
+			if issue := addTypeHint(types, strings.TrimPrefix(key, "type."), toString(value), lineNum); issue != nil {
+				issues = append(issues, issue)
+			}
+		case strings.HasPrefix(key, "variants."):
+			parts := strings.SplitN(key, ".", 3)
+			if len(parts) != 3 {
+				continue
+			}
+			if _, ok := variants[parts[1]]; !ok {
+				variants[parts[1]] = make(map[string]any)
+			}
+			variants[parts[1]][parts[2]] = value
+		case key == stopMetadataKey:
+			// A single stop sequence is a list of one
+			if s, ok := value.(string); ok {
+				value = []string{s}
+			}
+			metadata[key] = value
+		case key == "version":
+			// Versions like 1.10 are kept as written
+			if s, ok := value.(string); ok {
+				metadata[key] = s
+			} else {
+				metadata[key] = raw
+			}
+		case table != "":
+			nested, ok := metadata[table].(map[string]any)
+			if !ok {
+				nested = make(map[string]any)
+				metadata[table] = nested
+			}
+			nested[strings.TrimPrefix(key, table+".")] = value
+		default:
+			metadata[key] = value
+		}
+	}
+
+	if len(variants) > 0 {
+		metadata["variants"] = variants
+	}
+	if len(fallbacks) > 0 {
+		metadata["fallbacks"] = fallbacks
+	}
+	if len(validators) > 0 {
+		metadata["validate"] = validators
+	}
+	if len(render) > 0 {
+		metadata["render_modes"] = render
+	}
+	if len(types) > 0 {
+		metadata["type_hints"] = types
+	}
+	splitConditionalDefaults(metadata, defaults)
+
+	// A missing closing fence explains any other issues, so report it first
+	if end == len(lines) {
+		issues = append([]*ParseError{{
+			Line:    1,
+			Message: "unterminated front-matter block, missing closing " + tomlFrontMatterDelimiter,
+		}}, issues...)
+		return metadata, "", issues
+	}
+
+	content := strings.Join(lines[end+1:], "\n")
+	content = strings.TrimRight(strings.TrimLeft(content, "\n"), "\n")
+	return metadata, content, issues
+}
+
+// hasAnyPrefix reports whether s starts with one of the prefixes
+func hasAnyPrefix(s string, prefixes ...string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTOMLTable returns the name of a [table] header, arrays of tables are not supported
+func parseTOMLTable(line string) (string, bool) {
+	if i := strings.Index(line, "#"); i != -1 {
+		line = strings.TrimSpace(line[:i])
+	}
+	if strings.HasPrefix(line, "[[") || !strings.HasSuffix(line, "]") {
+		return "", false
+	}
+	name := strings.TrimSpace(line[1 : len(line)-1])
+	return name, name != ""
+}
+
+// parseTOMLValue converts a one-line TOML value followed by an optional # comment
+func parseTOMLValue(raw string) (any, error) {
+	value, rest, err := scanTOMLValue(raw)
+	if err != nil {
+		return nil, err
+	}
+	if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+		return nil, fmt.Errorf("unexpected %q after value", rest)
+	}
+	return value, nil
+}
+
+// scanTOMLValue reads one value from the start of s and returns the remaining text
+// Strings, numbers and bools are converted, arrays become []string with nested arrays flattened
+func scanTOMLValue(s string) (any, string, error) {
+	s = strings.TrimLeft(s, " \t")
+	if s == "" {
+		return nil, "", fmt.Errorf("missing value")
+	}
+
+	switch s[0] {
+	case '"':
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				value, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return nil, "", fmt.Errorf("invalid string %s", s[:i+1])
+				}
+				return value, s[i+1:], nil
+			}
+		}
+		return nil, "", fmt.Errorf("unterminated string")
+	case '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end == -1 {
+			return nil, "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	case '[':
+		items := []string{}
+		rest := s[1:]
+		for {
+			rest = strings.TrimLeft(rest, " \t")
+			if strings.HasPrefix(rest, "]") {
+				return items, rest[1:], nil
+			}
+
+			item, next, err := scanTOMLValue(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			if list, ok := item.([]string); ok {
+				items = append(items, list...)
+			} else {
+				items = append(items, toString(item))
+			}
+
+			rest = strings.TrimLeft(next, " \t")
+			if strings.HasPrefix(rest, ",") {
+				rest = rest[1:]
+			} else if !strings.HasPrefix(rest, "]") {
+				return nil, "", fmt.Errorf("unterminated array")
+			}
+		}
+	}
+
+	// Bare values end at a separator, comment or whitespace
+	end := strings.IndexAny(s, ",]# \t")
+	if end == -1 {
+		end = len(s)
+	}
+	token, rest := s[:end], s[end:]
+
+	switch token {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	number := strings.ReplaceAll(token, "_", "")
+	if n, err := strconv.Atoi(number); err == nil {
+		return n, rest, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, rest, nil
+	}
+	// Dates and other bare values are kept as text
+	return token, rest, nil
+}
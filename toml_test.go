@@ -0,0 +1,160 @@
+package echotemplates
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOMLValue(t *testing.T) {
+	tests := []struct {
+		raw         string
+		expected    any
+		expectError bool
+	}{
+		{`"gpt-4"`, "gpt-4", false},
+		{`"say \"hi\"\n"`, "say \"hi\"\n", false},
+		{`'C:\path'`, `C:\path`, false},
+		{`42`, 42, false},
+		{`1_000`, 1000, false},
+		{`0.7`, 0.7, false},
+		{`true`, true, false},
+		{`false # disabled`, false, false},
+		{`["support", 'chat', 3]`, []string{"support", "chat", "3"}, false},
+		{`[["a", "b"], ["c"]]`, []string{"a", "b", "c"}, false},
+		{`[ ]`, []string{}, false},
+		{`["a", "b",]`, []string{"a", "b"}, false},
+		{`2024-01-02`, "2024-01-02", false},
+		{`"unterminated`, nil, true},
+		{`["a"`, nil, true},
+		{`"a" "b"`, nil, true},
+		{``, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			value, err := parseTOMLValue(tt.raw)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error but got %v", value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(value, tt.expected) {
+				t.Errorf("parseTOMLValue(%q) = %#v, want %#v", tt.raw, value, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTOMLFrontMatter(t *testing.T) {
+	input := `+++
+# Prompt settings
+model = "gpt-4"
+temperature = 0.7
+max_tokens = 200
+stream = true
+version = 1.10
+tags = ["support", "chat"]
+default.tone = "friendly"
+
+[defaults]
+length = 3
+
+[fallbacks]
+name = "there"
+
+[variants.short]
+max_tokens = 50
+
+[owner]
+team = "support"
++++
+
+Hello {{name}}`
+
+	metadata, content, err := parseFrontMatter(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string]any{
+		"model":       "gpt-4",
+		"temperature": 0.7,
+		"max_tokens":  200,
+		"stream":      true,
+		"version":     "1.10",
+		"tags":        []string{"support", "chat"},
+		"defaults":    map[string]string{"tone": "friendly", "length": "3"},
+		"fallbacks":   map[string]string{"name": "there"},
+		"variants":    map[string]map[string]any{"short": {"max_tokens": 50}},
+		"owner":       map[string]any{"team": "support"},
+	}
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("Expected metadata %v, got %v", expected, metadata)
+	}
+	if content != "Hello {{name}}" {
+		t.Errorf("Expected content %q, got %q", "Hello {{name}}", content)
+	}
+
+	issueTests := []struct {
+		name  string
+		input string
+		lines []int
+	}{
+		{"valid", "+++\nmodel = \"gpt-4\"\n+++\nHi", nil},
+		{"yaml syntax", "+++\nmodel: gpt-4\n+++\nHi", []int{2}},
+		{"bad value", "+++\nmodel = \"gpt-4\n+++\nHi", []int{2}},
+		{"duplicate", "+++\nmodel = \"a\"\nmodel = \"b\"\n+++\nHi", []int{3}},
+		{"array of tables", "+++\n[[items]]\n+++\nHi", []int{2}},
+		{"unterminated", "+++\nmodel = \"gpt-4\"\nHi", []int{1, 3}},
+	}
+
+	for _, tt := range issueTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, issues, err := scanFrontMatter(strings.NewReader(tt.input), "")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var lines []int
+			for _, issue := range issues {
+				lines = append(lines, issue.Line)
+			}
+			if !reflect.DeepEqual(lines, tt.lines) {
+				t.Errorf("Expected issues at lines %v, got %v", tt.lines, issues)
+			}
+		})
+	}
+}
+
+func TestTOMLTemplates(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"greet.md": "+++\nmodel = \"gpt-4\"\ntags = [\"a\", \"b\"]\n\n[defaults]\nname = \"World\"\n+++\n@user:\nHello {{name}} ({{tags}})\n{{broken",
+	})
+
+	engine, err := New(Config{Source: source})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	messages, metadata, err := engine.GenerateWithMetadata("greet", map[string]any{"tags": []string{"x", "y"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if messages[0].Content != "Hello World (x,y)\n{{broken" {
+		t.Errorf("Unexpected content %q", messages[0].Content)
+	}
+	if metadata["model"] != "gpt-4" {
+		t.Errorf("Expected model gpt-4, got %v", metadata["model"])
+	}
+
+	// Line numbers account for the TOML block
+	var parseErr *ParseError
+	if err := engine.ValidateTemplate("greet"); !errors.As(err, &parseErr) || parseErr.Line != 10 {
+		t.Errorf("Expected parse error at line 10, got %v", err)
+	}
+}
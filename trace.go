@@ -0,0 +1,159 @@
+package echotemplates
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mkozhukh/echo"
+)
+
+// TraceSpan maps a byte range of a generated message back to a template line
+// Returned under the "_trace" metadata key when GenerateOptions.Trace is set
+type TraceSpan struct {
+	// Message is the index of the message in the generated slice
+	Message int
+
+	// Start and End delimit the range in the message content
+	Start int
+	End   int
+
+	// Template and Line locate the source, lines count from 1 in the template file
+	Template string
+	Line     int
+}
+
+// Trace markers are appended to source lines while imports are expanded and
+// removed once messages are parsed, as private-use runes they never clash
+// with placeholders or role markers
+const (
+	traceOpen  = "\uE002"
+	traceClose = "\uE003"
+)
+
+// traceSource is a template line a trace marker points to
+type traceSource struct {
+	template string
+	line     int
+}
+
+// traceTable collects the sources of trace markers for one generation
+type traceTable struct {
+	sources []traceSource
+	ids     map[traceSource]int
+}
+
+// mark appends a trace marker to every line of content that can produce output
+// Blank lines, role markers, lone {{#if}} tags and block markers are left as is,
+// so they are recognized by later stages; a nil table returns content unchanged
+func (t *traceTable) mark(content, template string, lineOffset int) string {
+	if t == nil {
+		return content
+	}
+
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || blockMarkerRegex.MatchString(line) || isLoneConditionTag(trimmed) {
+			continue
+		}
+		if _, _, ok := parseRoleMarker(line); ok {
+			continue
+		}
+
+		// Keep trailing spaces after the marker, so trimming works as without it
+		end := len(strings.TrimRight(line, " \t\r"))
+		lines[i] = line[:end] + t.marker(template, lineOffset+i+1) + line[end:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// marker returns the trace marker for a template line
+func (t *traceTable) marker(template string, line int) string {
+	source := traceSource{template: template, line: line}
+	id, ok := t.ids[source]
+	if !ok {
+		if t.ids == nil {
+			t.ids = make(map[traceSource]int)
+		}
+		id = len(t.sources)
+		t.sources = append(t.sources, source)
+		t.ids[source] = id
+	}
+	return traceOpen + strconv.Itoa(id) + traceClose
+}
+
+// isLoneConditionTag reports whether a trimmed line holds a single {{#if}}, {{else}} or {{/if}} tag
+func isLoneConditionTag(trimmed string) bool {
+	loc := conditionTagRegex.FindStringIndex(trimmed)
+	return loc != nil && loc[0] == 0 && loc[1] == len(trimmed)
+}
+
+// extract removes trace markers from messages and returns the spans they delimit
+// The text before a marker, up to the previous marker, comes from the marker's line
+// Messages left empty are dropped, trim applies the trimming of parsed messages
+func (t *traceTable) extract(messages []echo.Message, trim bool) ([]echo.Message, []TraceSpan) {
+	var spans []TraceSpan
+	result := make([]echo.Message, 0, len(messages))
+
+	for _, msg := range messages {
+		var b strings.Builder
+		var msgSpans []TraceSpan
+		prev := 0
+		content := msg.Content
+		for {
+			open := strings.Index(content, traceOpen)
+			if open == -1 {
+				b.WriteString(content)
+				break
+			}
+			b.WriteString(content[:open])
+			rest := content[open+len(traceOpen):]
+			end := strings.Index(rest, traceClose)
+			if end == -1 {
+				b.WriteString(content[open:])
+				break
+			}
+			content = rest[end+len(traceClose):]
+
+			id, err := strconv.Atoi(rest[:end])
+			if err != nil || id >= len(t.sources) {
+				continue
+			}
+
+			// A span starts after the previous marker and the rest of its line
+			start := prev
+			text := b.String()
+			prev = len(text)
+			if idx := strings.IndexByte(text[start:], '\n'); idx != -1 && strings.TrimSpace(text[start:start+idx]) == "" {
+				start += idx + 1
+			}
+			if start < len(text) {
+				source := t.sources[id]
+				msgSpans = append(msgSpans, TraceSpan{Start: start, End: len(text), Template: source.template, Line: source.line})
+			}
+		}
+
+		text := b.String()
+		if trim {
+			shift := len(text) - len(strings.TrimLeftFunc(text, unicode.IsSpace))
+			text = strings.TrimSpace(text)
+			for i := range msgSpans {
+				msgSpans[i].Start = min(max(msgSpans[i].Start-shift, 0), len(text))
+				msgSpans[i].End = min(max(msgSpans[i].End-shift, 0), len(text))
+			}
+		}
+		if text == "" {
+			continue
+		}
+
+		for _, span := range msgSpans {
+			if span.Start < span.End {
+				span.Message = len(result)
+				spans = append(spans, span)
+			}
+		}
+		result = append(result, echo.Message{Role: msg.Role, Content: text})
+	}
+	return result, spans
+}
@@ -0,0 +1,81 @@
+package echotemplates
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	source := NewMockSource(map[string]string{
+		"main.md":           "---\nmodel: gpt-4\n---\n@system:\nYou are {{role}}.   \n{{@partials/rules}}\n{{#if strict}}\nNo jokes.\n{{/if}}\n\n@user:\n{{question}}",
+		"partials/rules.md": "---\nversion: 2\n---\nRule one.\nAsk {{name}} politely.",
+	})
+
+	engine, err := New(Config{Source: source})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	vars := map[string]any{"role": "helpful", "name": "Bob", "strict": true, "question": "What is Go?"}
+	plain, _, err := engine.GenerateWithMetadata("main", vars)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	messages, metadata, err := engine.GenerateWithMetadata("main", vars, GenerateOptions{Trace: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Tracing doesn't change the output
+	if !reflect.DeepEqual(messages, plain) {
+		t.Fatalf("Expected %v, got %v", plain, messages)
+	}
+
+	spans, ok := metadata["_trace"].([]TraceSpan)
+	if !ok {
+		t.Fatalf("Expected _trace metadata, got %#v", metadata["_trace"])
+	}
+
+	expected := []struct {
+		text     string
+		template string
+		line     int
+	}{
+		{"You are helpful.", "main.md", 5},
+		{"Rule one.", "partials/rules.md", 4},
+		{"Ask Bob politely.", "partials/rules.md", 5},
+		{"No jokes.", "main.md", 8},
+		{"What is Go?", "main.md", 12},
+	}
+	if len(spans) != len(expected) {
+		t.Fatalf("Expected %d spans, got %v", len(expected), spans)
+	}
+	for i, want := range expected {
+		span := spans[i]
+		text := messages[span.Message].Content[span.Start:span.End]
+		if text != want.text || span.Template != want.template || span.Line != want.line {
+			t.Errorf("Span %d: got %q from %s:%d, want %q from %s:%d", i, text, span.Template, span.Line, want.text, want.template, want.line)
+		}
+	}
+
+	// Without the option there is no trace
+	_, plainMetadata, _ := engine.GenerateWithMetadata("main", vars)
+	if _, ok := plainMetadata["_trace"]; ok {
+		t.Error("Expected no _trace metadata without Trace")
+	}
+}
+
+func TestTraceStringTemplate(t *testing.T) {
+	messages, metadata, err := GenerateWithMetadata("Hello {{name}}\nBye", map[string]any{"name": "Ann"}, GenerateOptions{Trace: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if messages[0].Content != "Hello Ann\nBye" {
+		t.Errorf("Expected %q, got %q", "Hello Ann\nBye", messages[0].Content)
+	}
+
+	spans := metadata["_trace"].([]TraceSpan)
+	if len(spans) != 2 || spans[1].Line != 2 || messages[0].Content[spans[1].Start:spans[1].End] != "Bye" {
+		t.Errorf("Unexpected spans %v", spans)
+	}
+}